@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
@@ -18,9 +22,104 @@ type AcceptMr struct {
 	ProjectName        string
 	FailOnError        bool
 	Message            string
+	FailureStrategy    string
+	FailureLabel       string
+	RequiredLabels     []string
+	ExcludedLabels     []string
+	StatusNote         bool
+	StatusTemplate     *template.Template
+	SlashCommands      bool
+	RequireCommand     bool
+	CommandMinAccess   gitlab.AccessLevelValue
+	ReportFile         string
+	ReportFormat       string
+	JUnitFile          string
+	LastReport         *RunReport
+	Metrics            *Metrics
+	Tracer             *Tracer
+	RetryPolicy        *RetryPolicy
+	Limiter            *APILimiter
+	MergeLimits        *MergeLimits
+	Schedule           *Schedule
+	State              StateStore
+	Quarantine         *Quarantine
+	DependencyPolicy   *DependencyPolicy
+	ChangeLimits       *ChangeLimits
+	PathRules          *PathRules
+	CodeOwners         bool
+	Conflicts          *ConflictPolicy
+	Verification       *Verification
+	ResolveBotThreads  bool
+	BotAccounts        []string
+
+	user              *gitlab.User
+	userLoaded        bool
+	userAccess        map[int64]gitlab.AccessLevelValue
+	changes           map[int64]*MergeRequestChanges
+	codeOwnersFiles   map[string]*CodeOwners
+	ownerChecks       map[int64]Check
+	groupMembership   map[string]bool
+	emailUsers        map[string]string
+	unresolvedThreads map[int64]*UnresolvedThreads
+	intents           map[int64]string
+	pausedBranches    map[string]string
+	verifyFailures    map[int64]bool
+}
+
+// Run accepts merge requests of project.
+func (a *AcceptMr) Run() error {
+	return a.RunContext(context.Background())
 }
 
-func (a AcceptMr) Run() error {
+// RunContext accepts merge requests of project, api calls are cancelled when ctx deadline is reached.
+// When ctx is cancelled, no new merge request is processed but the one in progress is finished.
+func (a *AcceptMr) RunContext(ctx context.Context) (err error) {
+	report := newRunReport(a.ProjectName)
+	a.LastReport = report
+	retriesAtStart := a.RetryPolicy.Retries()
+	a.Limiter.Reset()
+	a.MergeLimits.startRun()
+	a.MergeLimits.loadMerges(a.mergeRequestStates())
+	// access, files and memberships may change between runs in long-running mode
+	a.userAccess = nil
+	a.changes = nil
+	a.codeOwnersFiles = nil
+	a.ownerChecks = nil
+	a.groupMembership = nil
+	a.emailUsers = nil
+	a.unresolvedThreads = nil
+	a.intents = nil
+	a.verifyFailures = nil
+	span := a.Tracer.Start("run")
+	span.SetAttribute("project", a.ProjectName)
+	span.SetAttribute("run.id", report.RunID)
+	defer func() {
+		report.Totals.Retries = a.RetryPolicy.Retries() - retriesAtStart
+		report.Totals.APICalls = a.Limiter.Calls()
+		report.finish()
+		a.Metrics.ObserveRun(a.ProjectName, err)
+		span.SetAttribute("merge_requests.considered", report.Totals.Considered)
+		span.SetAttribute("merge_requests.merged", report.Totals.Merged)
+		span.SetError(err)
+		span.End()
+		if flushErr := a.Tracer.Flush(); flushErr != nil {
+			log.Warnf("could not export traces: %s", flushErr.Error())
+		}
+		if a.State != nil {
+			if flushErr := a.State.Flush(); flushErr != nil {
+				log.Errorf("could not save state: %s", flushErr.Error())
+			}
+		}
+		writeErr := a.writeReports(report)
+		if writeErr == nil {
+			return
+		}
+		if err != nil {
+			log.Error(writeErr.Error())
+			return
+		}
+		err = writeErr
+	}()
 	options := &gitlab.AcceptMergeRequestOptions{}
 	if a.RemoveSourceBranch {
 		options.ShouldRemoveSourceBranch = &a.RemoveSourceBranch
@@ -28,31 +127,80 @@ func (a AcceptMr) Run() error {
 	state := "opened"
 	mrs, _, err := a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
 		State: &state,
-	})
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		report.addError(err)
+		return err
+	}
+	err = a.Schedule.loadFreezePeriods(ctx, a.Client, a.ProjectName)
 	if err != nil {
+		report.addError(err)
 		return err
 	}
+	err = a.loadPausedBranches(ctx)
+	if err != nil {
+		report.addError(err)
+		return err
+	}
+	if a.Verification.enabled() {
+		a.verifyMerges(ctx, report, false)
+	}
 	log.Infof("On build succeed: %t", a.OnBuildSucceed)
 	log.Infof("Remove source branch: %t", a.RemoveSourceBranch)
 	nbErrors := 0
-	for _, mr := range mrs {
-		entry := log.WithFields(log.Fields(map[string]interface{}{
-			"title": mr.Title,
-		}))
-		if mr.Draft {
-			entry.Warn("Skipping merge request, it is in WIP")
+	for i, mr := range mrs {
+		a.Metrics.SetQueueDepth(a.ProjectName, len(mrs)-i)
+		result := newMergeRequestResult(mr)
+		if rule, reason, stop := a.stopReason(ctx); stop {
+			result.decide(DecisionUnprocessed, rule, reason)
+			report.add(result)
+			a.Metrics.ObserveResult(a.ProjectName, result, mr.CreatedAt)
 			continue
 		}
-		if a.OnBuildSucceed && mr.MergeWhenPipelineSucceeds {
+		state := a.mergeRequestState(mr.IID)
+		if until, quarantined := a.Quarantine.check(state, mr, time.Now()); quarantined {
+			result.decide(DecisionQuarantined, "quarantine", quarantineReason(state, until))
+			result.NextRetryAt = &until
+			a.mrLogger(result).Infof("Skipping merge request, %s", result.Reason)
+			a.addResult(report, state, mr, result)
 			continue
 		}
-		entry.Info("Accepting merge request ...")
-		err := a.accept(mr, options)
+		retries := a.RetryPolicy.Retries()
+		mrSpan := a.Tracer.Start("merge request")
+		mrSpan.SetAttribute("project", a.ProjectName)
+		mrSpan.SetAttribute("mr.iid", mr.IID)
+		mrSpan.SetAttribute("mr.title", mr.Title)
+		mrCtx, cancel := inFlightContext(ctx)
+		err := a.processMergeRequest(mrCtx, mr, options, result)
+		cancel()
+		if err != nil && result.Decision != DecisionMerged && a.Limiter.Exhausted() {
+			result.decide(DecisionUnprocessed, "budget", ErrAPIBudgetExhausted.Error())
+			err = nil
+		}
 		if err != nil {
 			nbErrors++
-			entry.Error(err.Error())
 		}
-		entry.Info("Finished accepting merge request ...")
+		result.Retries = a.RetryPolicy.Retries() - retries
+		mrSpan.SetAttribute("decision", string(result.Decision))
+		mrSpan.SetAttribute("reason", result.Reason)
+		mrSpan.SetError(err)
+		mrSpan.End()
+		a.addResult(report, state, mr, result)
+	}
+	a.Metrics.SetQueueDepth(a.ProjectName, 0)
+	if _, reason, stop := a.stopReason(ctx); stop {
+		report.finish()
+		log.Warnf("Run stopped, %s: %d merge request(s) not processed", reason, report.Totals.Unprocessed)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("run stopped, %s", reason)
+		}
+	}
+	if _, _, stop := a.stopReason(ctx); !stop && a.Verification.enabled() && a.State != nil {
+		// merges are saved before waiting, so they are verified on next run if this one is killed
+		if flushErr := a.State.Flush(); flushErr != nil {
+			log.Errorf("could not save state: %s", flushErr.Error())
+		}
+		a.verifyMerges(ctx, report, true)
 	}
 	if a.FailOnError && nbErrors > 0 {
 		return fmt.Errorf("you have %d merge request which can't be accepted", nbErrors)
@@ -60,69 +208,223 @@ func (a AcceptMr) Run() error {
 	return nil
 }
 
-func (a AcceptMr) accept(mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions) error {
+// addResult records result of merge request in report, state and metrics.
+func (a *AcceptMr) addResult(report *RunReport, state *MergeRequestState, mr *gitlab.BasicMergeRequest, result *MergeRequestResult) {
+	if err := a.recordState(report.RunID, state, result, mr.Labels); err != nil {
+		a.mrLogger(result).Warnf("could not record merge request state: %s", err.Error())
+	}
+	if result.NextRetryAt != nil && result.Decision == DecisionFailed {
+		a.mrLogger(result).Warnf("Merge request quarantined until %s", result.NextRetryAt.Format(time.RFC3339))
+	}
+	report.add(result)
+	a.Metrics.ObserveResult(a.ProjectName, result, mr.CreatedAt)
+}
+
+// stopReason tells if run must stop processing merge requests and why.
+func (a *AcceptMr) stopReason(ctx context.Context) (rule string, reason string, stop bool) {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout", "run timeout reached", true
+	case ctx.Err() != nil:
+		return "interrupted", "run interrupted", true
+	case a.Limiter.Exhausted():
+		return "budget", ErrAPIBudgetExhausted.Error(), true
+	}
+	return "", "", false
+}
+
+// inFlightContext returns a context which is not cancelled with ctx, to let a merge request in progress
+// be finished, but which keeps ctx deadline.
+func inFlightContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	inFlight := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(inFlight, deadline)
+	}
+	return inFlight, func() {}
+}
+
+func (a *AcceptMr) writeReports(report *RunReport) error {
+	if a.ReportFile != "" {
+		err := report.write(a.ReportFile, a.ReportFormat)
+		if err != nil {
+			return fmt.Errorf("error when writing report: %s", err.Error())
+		}
+	}
+	if a.JUnitFile != "" {
+		err := report.writeJUnit(a.JUnitFile)
+		if err != nil {
+			return fmt.Errorf("error when writing junit report: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (a *AcceptMr) processMergeRequest(ctx context.Context, mr *gitlab.BasicMergeRequest, options *gitlab.AcceptMergeRequestOptions, result *MergeRequestResult) error {
+	cleared, err := a.clearFailed(ctx, mr)
+	if err != nil {
+		result.addError(err)
+		a.mrLogger(result).Warnf("could not remove failure marker: %s", err.Error())
+	}
+	if cleared {
+		a.mrLogger(result).Info("Merge request can be merged again, failure marker removed")
+	}
+	if a.SlashCommands {
+		err := a.applySlashCommands(ctx, mr, a.mrLogger(result))
+		if err != nil {
+			result.addError(err)
+			a.mrLogger(result).Warnf("could not read slash commands: %s", err.Error())
+		}
+	}
+	err = a.handleDiscussions(ctx, mr, a.mrLogger(result))
+	if err != nil {
+		result.addError(err)
+		a.mrLogger(result).Warn(err.Error())
+	}
+	if a.Conflicts.enabled() {
+		err := a.handleConflicts(ctx, mr, a.mrLogger(result))
+		if err != nil {
+			result.addError(err)
+			a.mrLogger(result).Warnf("could not handle conflicts: %s", err.Error())
+		}
+	}
+	if a.needsChanges() {
+		err := a.loadChanges(ctx, mr)
+		if err != nil {
+			result.addError(err)
+			a.mrLogger(result).Warn(err.Error())
+		}
+	}
+	if a.CodeOwners {
+		err := a.loadCodeOwnersCheck(ctx, mr)
+		if err != nil {
+			result.addError(err)
+			a.mrLogger(result).Warnf("could not check approvals of code owners: %s", err.Error())
+		}
+	}
+	checks := a.checkEligibility(mr)
+	if failedCheck := checks.Failed(); failedCheck != nil {
+		reason := fmt.Sprintf("%s check failed: %s", strings.ToLower(failedCheck.Name), failedCheck.Detail)
+		result.decide(DecisionSkipped, strings.ToLower(failedCheck.Name), reason)
+		a.mrLogger(result).Warnf("Skipping merge request, %s", reason)
+		if a.StatusNote {
+			err := a.writeStatusNote(ctx, mr, checks, false, "")
+			if err != nil {
+				result.addError(err)
+				a.mrLogger(result).Warnf("could not write status note: %s", err.Error())
+			}
+		}
+		return nil
+	}
+	a.mrLogger(result).Info("Accepting merge request ...")
+	mrOptions := *options
+	if a.intent(mr) == IntentLabelSquash {
+		mrOptions.Squash = gitlab.Ptr(true)
+	}
+	err = a.accept(ctx, mr, &mrOptions, result)
+	if err != nil {
+		result.addError(err)
+		if result.Decision == "" {
+			result.decide(DecisionFailed, "error", err.Error())
+		}
+		a.mrLogger(result).Error(err.Error())
+	}
+	a.mrLogger(result).Info("Finished accepting merge request ...")
+	return err
+}
+
+func (a *AcceptMr) accept(ctx context.Context, mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions, result *MergeRequestResult) error {
 	if a.OnBuildSucceed {
-		return a.acceptBuildSucceed(mr, opt)
+		return a.acceptBuildSucceed(ctx, mr, opt, result)
 	}
-	return a.acceptMrRequest(mr, opt)
+	return a.acceptMrRequest(ctx, mr, opt, result)
 }
 
-func (a AcceptMr) acceptMrRequest(mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions) error {
-	info, resp, err := a.Client.MergeRequests.AcceptMergeRequest(a.ProjectName, mr.IID, opt)
+func (a *AcceptMr) acceptMrRequest(ctx context.Context, mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions, result *MergeRequestResult) error {
+	if rule, reason, ok := a.MergeLimits.check(mr); !ok {
+		result.decide(DecisionDeferred, rule, reason)
+		a.mrLogger(result).Infof("Deferring merge request, %s", reason)
+		return nil
+	}
+	info, resp, err := a.Client.MergeRequests.AcceptMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
 	if err != nil {
 		if resp != nil && resp.StatusCode == http.StatusMethodNotAllowed {
+			unresolved, threadsErr := a.loadUnresolvedThreads(ctx, mr, false, a.mrLogger(result))
+			if threadsErr == nil && unresolved.Count > 0 {
+				return fmt.Errorf("merging process is blocked by %s", unresolved)
+			}
 			return fmt.Errorf("merging process is blocked (grey button on MR web view). MR is probably in unresolved thread state")
 		}
+		if resp != nil && resp.StatusCode == http.StatusNotAcceptable && a.Conflicts.enabled() {
+			result.decide(DecisionFailed, "conflicts", "merge request has conflicts")
+			a.mrLogger(result).Warn("could not merge request due to conflicts")
+			mr.HasConflicts = true
+			err := a.notifyConflict(ctx, mr)
+			if err != nil {
+				return fmt.Errorf("error occurred while notifying conflicts: %s ", err.Error())
+			}
+			return nil
+		}
 		return fmt.Errorf("error occurred while accepting: %s ", err.Error())
 	}
 
+	if len(info.MergeError) != 0 {
+		result.decide(DecisionFailed, "merge_error", info.MergeError)
+	} else {
+		result.decide(DecisionMerged, "", "")
+		result.MergeCommitSHA = mergedCommitSHA(info)
+		a.MergeLimits.merged(mr)
+	}
+
 	if a.Message != "" {
 		_, _, err := a.Client.Notes.CreateMergeRequestNote(a.ProjectName, mr.IID, &gitlab.CreateMergeRequestNoteOptions{
 			Body: &a.Message,
-		})
+		}, gitlab.WithContext(ctx))
 		if err != nil {
 			return fmt.Errorf("error when commenting on merge request: %s ", err.Error())
 		}
 	}
 
 	if len(info.MergeError) != 0 {
-		log.WithFields(log.Fields(map[string]interface{}{
-			"title": mr.Title,
-		})).Warnf("could not merge request due to merge error: %s", info.MergeError)
-
-		// Best effort, no error checking
-		newTitle := "WIP: " + mr.Title
-		_, _, err := a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, &gitlab.UpdateMergeRequestOptions{
-			Title: &newTitle,
-		})
+		a.mrLogger(result).Warnf("could not merge request due to merge error: %s", info.MergeError)
+
+		err := a.markFailed(ctx, mr)
 		if err != nil {
 			return fmt.Errorf("error occurred while updating merge request: %s ", err.Error())
 		}
-		// Best effort, no error checking
-		msg := "Could not merge automatically due to merge error: " + info.MergeError
-		_, _, err = a.Client.Notes.CreateMergeRequestNote(a.ProjectName, mr.IID, &gitlab.CreateMergeRequestNoteOptions{
-			Body: &msg,
-		})
+		err = a.notifyMergeError(ctx, mr, info.MergeError)
 		if err != nil {
 			return fmt.Errorf("error occurred while commenting on merge request: %s ", err.Error())
 		}
+		return nil
+	}
+	if a.StatusNote {
+		err := a.writeStatusNote(ctx, mr, a.checkEligibility(mr), true, "")
+		if err != nil {
+			return fmt.Errorf("error occurred while updating status note: %s ", err.Error())
+		}
+	}
+	err = a.closeBatchedMergeRequests(ctx, mr)
+	if err != nil {
+		return fmt.Errorf("error occurred while closing batched merge requests: %s ", err.Error())
 	}
+	a.awaitVerification(mr, result)
 	return nil
 }
 
-func (a AcceptMr) acceptBuildSucceed(mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions) error {
-	statuses, _, _ := a.Client.Commits.GetCommitStatuses(a.ProjectName, mr.SHA, nil)
+func (a *AcceptMr) acceptBuildSucceed(ctx context.Context, mr *gitlab.BasicMergeRequest, opt *gitlab.AcceptMergeRequestOptions, result *MergeRequestResult) error {
+	statuses, _, _ := a.Client.Commits.GetCommitStatuses(a.ProjectName, mr.SHA, nil, gitlab.WithContext(ctx))
 	if len(statuses) > 0 && statuses[0].Status == string(gitlab.Success) {
-		return a.acceptMrRequest(mr, opt)
+		return a.acceptMrRequest(ctx, mr, opt, result)
 	}
-	err := a.updateCommitStatus(statuses, mr.SHA)
+	result.decide(DecisionPending, "pipeline", "waiting for pipeline to succeed")
+	err := a.updateCommitStatus(ctx, statuses, mr.SHA)
 	if err != nil {
 		return fmt.Errorf("error occurred while changing status: %s ", err.Error())
 	}
 	return nil
 }
 
-func (a AcceptMr) updateCommitStatus(statuses []*gitlab.CommitStatus, sha string) error {
+func (a *AcceptMr) updateCommitStatus(ctx context.Context, statuses []*gitlab.CommitStatus, sha string) error {
 	if len(statuses) > 0 && statuses[0].Status != "" {
 		return nil
 	}
@@ -141,7 +443,7 @@ func (a AcceptMr) updateCommitStatus(statuses []*gitlab.CommitStatus, sha string
 	_, _, err := a.Client.Commits.SetCommitStatus(a.ProjectName, sha, &gitlab.SetCommitStatusOptions{
 		State: *gitlab.Ptr(stateValue),
 		Name:  &a.PipelineName,
-	})
+	}, gitlab.WithContext(ctx))
 	if err != nil {
 		return err
 	}
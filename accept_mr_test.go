@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gitlab.com/gitlab-org/api/client-go"
@@ -75,3 +77,59 @@ func TestAcceptMr_Run(t *testing.T) {
 	err = acceptMr.Run()
 	assert.NoError(t, err)
 }
+
+func TestAcceptMr_RunContextInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo"}, {"iid": 2, "title": "Bump bar"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			// interrupted while merging, merge in progress must be finished
+			cancel()
+			time.Sleep(10 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			_, _ = w.Write([]byte(`{"body": "Merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", Message: "Merged", FailOnError: true}
+	err = acceptMr.RunContext(ctx)
+	assert.NoError(t, err)
+	report := acceptMr.LastReport
+	assert.Equal(t, DecisionMerged, report.MergeRequests[0].Decision)
+	assert.Empty(t, report.MergeRequests[0].Errors)
+	assert.Equal(t, DecisionUnprocessed, report.MergeRequests[1].Decision)
+	assert.Equal(t, "run interrupted", report.MergeRequests[1].Reason)
+}
+
+func TestAcceptMr_RunContextTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo"}, {"iid": 2, "title": "Bump bar"}]`))
+		default:
+			// gitlab hangs
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL), gitlab.WithoutRetries())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+	err = acceptMr.RunContext(ctx)
+	assert.EqualError(t, err, "run stopped, run timeout reached")
+	report := acceptMr.LastReport
+	assert.Equal(t, DecisionFailed, report.MergeRequests[0].Decision)
+	assert.Equal(t, DecisionUnprocessed, report.MergeRequests[1].Decision)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Labels set by batch command, on batch merge requests and on merge requests they include.
+const (
+	BatchLabel   = "automerge-batch"
+	BatchedLabel = "automerge-batched"
+)
+
+// batchMarker records in batch merge request description the merge requests it includes.
+var batchMarker = regexp.MustCompile(`<!-- accept-mr:batch ([\d,]+) -->`)
+
+// BatchOptions defines how bot merge requests are combined by batch command.
+type BatchOptions struct {
+	// BranchPrefix is prefixed to target branch to name the batch branch.
+	BranchPrefix string
+	MinSize      int
+	MaxSize      int
+}
+
+// Batch combines dependency bot merge requests targeting the same branch into one merge request per branch.
+// Merge requests included in a batch are labelled with BatchedLabel so they are not merged on their own,
+// they are closed once batch is merged and released if batch is closed.
+func (a *AcceptMr) Batch(ctx context.Context, opt BatchOptions) error {
+	err := a.settleBatches(ctx)
+	if err != nil {
+		return err
+	}
+	mrs, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+		return a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			State:       gitlab.Ptr("opened"),
+			OrderBy:     gitlab.Ptr("created_at"),
+			Sort:        gitlab.Ptr("asc"),
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	batched := make(map[string]bool)
+	candidates := make(map[string][]*gitlab.BasicMergeRequest)
+	for _, mr := range mrs {
+		if hasLabel(mr, BatchLabel) {
+			batched[mr.TargetBranch] = true
+			continue
+		}
+		if a.canBatch(mr) {
+			candidates[mr.TargetBranch] = append(candidates[mr.TargetBranch], mr)
+		}
+	}
+	targets := make([]string, 0, len(candidates))
+	for target := range candidates {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	nbErrors := 0
+	for _, target := range targets {
+		entry := log.WithFields(log.Fields{"project": a.ProjectName, "target_branch": target})
+		if batched[target] {
+			entry.Info("A batch is already open for branch, skipping")
+			continue
+		}
+		if len(candidates[target]) < opt.MinSize {
+			entry.Infof("Only %d merge request(s) to batch, skipping", len(candidates[target]))
+			continue
+		}
+		err := a.buildBatch(ctx, target, candidates[target], opt, entry)
+		if err != nil {
+			nbErrors++
+			entry.Errorf("could not build batch: %s", err.Error())
+		}
+	}
+	if nbErrors > 0 {
+		return fmt.Errorf("%d batch(es) could not be built", nbErrors)
+	}
+	return nil
+}
+
+// canBatch tells if merge request is a dependency update which can be merged along others.
+func (a *AcceptMr) canBatch(mr *gitlab.BasicMergeRequest) bool {
+	if parseDependencyUpdate(mr) == nil || hasLabel(mr, BatchedLabel) {
+		return false
+	}
+	checks := Checks{a.checkDraft(mr), a.checkConflicts(mr), a.checkLabels(mr)}
+	if a.DependencyPolicy.enabled() {
+		checks = append(checks, a.checkDependency(mr))
+	}
+	return checks.Failed() == nil
+}
+
+func (a *AcceptMr) buildBatch(ctx context.Context, target string, mrs []*gitlab.BasicMergeRequest, opt BatchOptions, entry *log.Entry) error {
+	branch, _, err := a.Client.Branches.GetBranch(a.ProjectName, target, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when reading target branch: %s", err.Error())
+	}
+	head := branch.Commit.ID
+	batchBranch := opt.BranchPrefix + target
+	included := make([]*gitlab.BasicMergeRequest, 0, len(mrs))
+	for _, mr := range mrs {
+		if opt.MaxSize > 0 && len(included) >= opt.MaxSize {
+			break
+		}
+		sha, err := a.cherryPickMergeRequest(ctx, mr, head, fmt.Sprintf("%s-%d", batchBranch, mr.IID))
+		if err != nil {
+			entry.WithField("iid", mr.IID).Warnf("Merge request can't be added to batch: %s", err.Error())
+			continue
+		}
+		head = sha
+		included = append(included, mr)
+	}
+	if len(included) < opt.MinSize {
+		entry.Infof("Only %d merge request(s) could be combined, skipping", len(included))
+		return nil
+	}
+	err = a.deleteBranch(ctx, batchBranch)
+	if err != nil {
+		return err
+	}
+	_, _, err = a.Client.Branches.CreateBranch(a.ProjectName, &gitlab.CreateBranchOptions{
+		Branch: &batchBranch,
+		Ref:    &head,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when creating batch branch: %s", err.Error())
+	}
+	batchMr, _, err := a.Client.MergeRequests.CreateMergeRequest(a.ProjectName, &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.Ptr(fmt.Sprintf("Batch of %d dependency updates", len(included))),
+		Description:        gitlab.Ptr(batchDescription(included)),
+		SourceBranch:       &batchBranch,
+		TargetBranch:       &target,
+		Labels:             &gitlab.LabelOptions{BatchLabel},
+		RemoveSourceBranch: gitlab.Ptr(true),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when creating batch merge request: %s", err.Error())
+	}
+	entry.Infof("Batch !%d created with %d merge requests", batchMr.IID, len(included))
+	for _, mr := range included {
+		_, _, err := a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, &gitlab.UpdateMergeRequestOptions{
+			AddLabels: &gitlab.LabelOptions{BatchedLabel},
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error when labelling merge request !%d: %s", mr.IID, err.Error())
+		}
+	}
+	return nil
+}
+
+// cherryPickMergeRequest applies commits of merge request on top of base in a scratch branch,
+// it returns the resulting commit. Scratch branch is removed afterward so a merge request
+// which doesn't apply cleanly leaves nothing behind.
+func (a *AcceptMr) cherryPickMergeRequest(ctx context.Context, mr *gitlab.BasicMergeRequest, base, scratch string) (string, error) {
+	commits, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.Commit, *gitlab.Response, error) {
+		return a.Client.MergeRequests.GetMergeRequestCommits(a.ProjectName, mr.IID, &gitlab.GetMergeRequestCommitsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", errors.New("merge request has no commit")
+	}
+	_, _, err = a.Client.Branches.CreateBranch(a.ProjectName, &gitlab.CreateBranchOptions{
+		Branch: &scratch,
+		Ref:    &base,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error when creating scratch branch: %s", err.Error())
+	}
+	defer func() {
+		if err := a.deleteBranch(ctx, scratch); err != nil {
+			log.Warn(err.Error())
+		}
+	}()
+	head := base
+	// commits are listed from the most recent
+	for _, commit := range slices.Backward(commits) {
+		picked, _, err := a.Client.Commits.CherryPickCommit(a.ProjectName, commit.ID, &gitlab.CherryPickCommitOptions{
+			Branch: &scratch,
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return "", fmt.Errorf("could not cherry-pick %s: %s", commit.ShortID, err.Error())
+		}
+		head = picked.ID
+	}
+	return head, nil
+}
+
+func (a *AcceptMr) deleteBranch(ctx context.Context, branch string) error {
+	resp, err := a.Client.Branches.DeleteBranch(a.ProjectName, branch, gitlab.WithContext(ctx))
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("error when deleting branch %s: %s", branch, err.Error())
+	}
+	return nil
+}
+
+func batchDescription(mrs []*gitlab.BasicMergeRequest) string {
+	var b strings.Builder
+	iids := make([]string, len(mrs))
+	b.WriteString("This merge request combines the following dependency updates:\n\n")
+	for i, mr := range mrs {
+		iids[i] = strconv.FormatInt(mr.IID, 10)
+		fmt.Fprintf(&b, "- !%d %s\n", mr.IID, mr.Title)
+	}
+	b.WriteString("\nThey will be closed once this merge request is merged.\n\n")
+	fmt.Fprintf(&b, "<!-- accept-mr:batch %s -->\n", strings.Join(iids, ","))
+	return b.String()
+}
+
+// batchedIIDs returns merge requests included in a batch merge request, nil if it is not a batch.
+func batchedIIDs(description string) []int64 {
+	m := batchMarker.FindStringSubmatch(description)
+	if m == nil {
+		return nil
+	}
+	var iids []int64
+	for _, iid := range strings.Split(m[1], ",") {
+		if n, err := strconv.ParseInt(iid, 10, 64); err == nil {
+			iids = append(iids, n)
+		}
+	}
+	return iids
+}
+
+// settleBatches closes merge requests included in merged batches and releases those of closed batches.
+func (a *AcceptMr) settleBatches(ctx context.Context) error {
+	pending, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+		return a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			State:       gitlab.Ptr("opened"),
+			Labels:      &gitlab.LabelOptions{BatchedLabel},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	batches, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+		return a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			Labels:      &gitlab.LabelOptions{BatchLabel},
+			OrderBy:     gitlab.Ptr("updated_at"),
+			Sort:        gitlab.Ptr("desc"),
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	batchOf := make(map[int64]*gitlab.BasicMergeRequest)
+	for _, batch := range batches {
+		for _, iid := range batchedIIDs(batch.Description) {
+			// most recent batch wins
+			if _, ok := batchOf[iid]; !ok {
+				batchOf[iid] = batch
+			}
+		}
+	}
+	for _, mr := range pending {
+		batch := batchOf[mr.IID]
+		switch {
+		case batch == nil || batch.State == "closed":
+			_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, &gitlab.UpdateMergeRequestOptions{
+				RemoveLabels: &gitlab.LabelOptions{BatchedLabel},
+			}, gitlab.WithContext(ctx))
+			if err == nil {
+				log.WithField("iid", mr.IID).Info("Batch closed, merge request released")
+			}
+		case batch.State == "merged":
+			err = a.closeBatched(ctx, batch.IID, mr.IID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeBatchedMergeRequests closes merge requests included in a batch which has just been merged.
+func (a *AcceptMr) closeBatchedMergeRequests(ctx context.Context, batch *gitlab.BasicMergeRequest) error {
+	if !hasLabel(batch, BatchLabel) {
+		return nil
+	}
+	for _, iid := range batchedIIDs(batch.Description) {
+		err := a.closeBatched(ctx, batch.IID, iid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AcceptMr) closeBatched(ctx context.Context, batchIID, iid int64) error {
+	body := fmt.Sprintf("Merged with batch !%d.", batchIID)
+	_, _, err := a.Client.Notes.CreateMergeRequestNote(a.ProjectName, iid, &gitlab.CreateMergeRequestNoteOptions{
+		Body: &body,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when commenting on merge request !%d: %s", iid, err.Error())
+	}
+	_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, iid, &gitlab.UpdateMergeRequestOptions{
+		StateEvent:   gitlab.Ptr("close"),
+		RemoveLabels: &gitlab.LabelOptions{BatchedLabel},
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when closing merge request !%d: %s", iid, err.Error())
+	}
+	log.WithField("iid", iid).Infof("Merge request closed, merged with batch !%d", batchIID)
+	return nil
+}
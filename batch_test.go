@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestBatchedIIDs(t *testing.T) {
+	description := batchDescription([]*gitlab.BasicMergeRequest{{IID: 3, Title: "Bump foo"}, {IID: 12, Title: "Bump bar"}})
+	assert.Contains(t, description, "- !3 Bump foo\n- !12 Bump bar\n")
+	assert.Equal(t, []int64{3, 12}, batchedIIDs(description))
+	assert.Nil(t, batchedIIDs("Bump foo"))
+}
+
+func TestAcceptMr_Batch(t *testing.T) {
+	var created gitlab.CreateMergeRequestOptions
+	var branches, deleted, labelled, picked []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path == "/api/v4/projects/test-project/merge_requests" && r.Method == http.MethodGet:
+			if r.URL.Query().Get("labels") != "" {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[
+				{"iid": 1, "title": "Bump foo from 1.0.0 to 1.0.1", "source_branch": "dependabot/go_modules/foo-1.0.1", "target_branch": "main"},
+				{"iid": 2, "title": "Bump bar from 1.0.0 to 1.1.0", "source_branch": "dependabot/go_modules/bar-1.1.0", "target_branch": "main"},
+				{"iid": 3, "title": "Bump baz from 1.0.0 to 1.0.1", "source_branch": "dependabot/go_modules/baz-1.0.1", "target_branch": "main"},
+				{"iid": 4, "title": "Bump qux from 1.0.0 to 1.0.1", "source_branch": "dependabot/go_modules/qux-1.0.1", "target_branch": "main", "has_conflicts": true},
+				{"iid": 5, "title": "Add feature", "source_branch": "feature", "target_branch": "main"},
+				{"iid": 6, "title": "Bump foo from 1.0.0 to 1.0.1", "source_branch": "dependabot/go_modules/foo-1.0.1-release", "target_branch": "release"}
+			]`))
+		case path == "/api/v4/projects/test-project/repository/branches/main":
+			_, _ = w.Write([]byte(`{"name": "main", "commit": {"id": "base"}}`))
+		case path == "/api/v4/projects/test-project/repository/branches" && r.Method == http.MethodPost:
+			var opt gitlab.CreateBranchOptions
+			_ = json.NewDecoder(r.Body).Decode(&opt)
+			branches = append(branches, *opt.Branch+"@"+*opt.Ref)
+			_, _ = w.Write([]byte(`{}`))
+		case strings.HasPrefix(path, "/api/v4/projects/test-project/repository/branches/") && r.Method == http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.RawPath, "/api/v4/projects/test-project/repository/branches/"))
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(path, "/commits") && strings.Contains(path, "/merge_requests/"):
+			iid := strings.Split(path, "/")[6]
+			_, _ = w.Write([]byte(`[{"id": "c` + iid + `", "short_id": "c` + iid + `"}]`))
+		case strings.HasSuffix(path, "/cherry_pick"):
+			sha := strings.Split(path, "/")[7]
+			if sha == "c2" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"message": "Sorry, we cannot cherry-pick this commit automatically."}`))
+				return
+			}
+			picked = append(picked, sha)
+			_, _ = w.Write([]byte(`{"id": "picked-` + sha + `"}`))
+		case path == "/api/v4/projects/test-project/merge_requests" && r.Method == http.MethodPost:
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_, _ = w.Write([]byte(`{"iid": 10}`))
+		case strings.HasPrefix(path, "/api/v4/projects/test-project/merge_requests/") && r.Method == http.MethodPut:
+			labelled = append(labelled, strings.Split(path, "/")[6])
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+	err = acceptMr.Batch(context.Background(), BatchOptions{BranchPrefix: "batch/", MinSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"batch/main-1@base", "batch/main-2@picked-c1", "batch/main-3@picked-c1", "batch/main@picked-c3"}, branches)
+	assert.Equal(t, []string{"batch%2Fmain-1", "batch%2Fmain-2", "batch%2Fmain-3", "batch%2Fmain"}, deleted)
+	assert.Equal(t, []string{"c1", "c3"}, picked)
+	assert.Equal(t, "batch/main", *created.SourceBranch)
+	assert.Equal(t, "main", *created.TargetBranch)
+	assert.Equal(t, "Batch of 2 dependency updates", *created.Title)
+	assert.Equal(t, []int64{1, 3}, batchedIIDs(*created.Description))
+	assert.Equal(t, []string{"1", "3"}, labelled)
+}
+
+func TestAcceptMr_settleBatches(t *testing.T) {
+	var closed, released, notes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path == "/api/v4/projects/test-project/merge_requests" && r.URL.Query().Get("labels") == BatchedLabel:
+			_, _ = w.Write([]byte(`[{"iid": 1}, {"iid": 2}, {"iid": 3}]`))
+		case path == "/api/v4/projects/test-project/merge_requests" && r.URL.Query().Get("labels") == BatchLabel && r.URL.Query().Get("page") == "2":
+			_, _ = w.Write([]byte(`[{"iid": 9, "state": "merged", "description": "<!-- accept-mr:batch 3 -->"}]`))
+		case path == "/api/v4/projects/test-project/merge_requests" && r.URL.Query().Get("labels") == BatchLabel:
+			w.Header().Set("X-Next-Page", "2")
+			_, _ = w.Write([]byte(`[
+				{"iid": 11, "state": "merged", "description": "<!-- accept-mr:batch 1 -->"},
+				{"iid": 10, "state": "closed", "description": "<!-- accept-mr:batch 1,2 -->"}
+			]`))
+		case strings.HasSuffix(path, "/notes"):
+			notes = append(notes, strings.Split(path, "/")[6])
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPut:
+			var opt gitlab.UpdateMergeRequestOptions
+			_ = json.NewDecoder(r.Body).Decode(&opt)
+			if opt.StateEvent != nil {
+				closed = append(closed, strings.Split(path, "/")[6])
+			} else {
+				released = append(released, strings.Split(path, "/")[6])
+			}
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+	err = acceptMr.settleBatches(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "3"}, closed)
+	assert.Equal(t, []string{"1", "3"}, notes)
+	assert.Equal(t, []string{"2"}, released)
+}
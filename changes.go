@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// MergeRequestChanges summarizes the diff of a merge request.
+type MergeRequestChanges struct {
+	Diffs   []*gitlab.MergeRequestDiff
+	Added   int
+	Removed int
+	// Unmeasured are files whose diff is too large to be sent by gitlab, their lines can't be counted.
+	Unmeasured []string
+}
+
+func newMergeRequestChanges(diffs []*gitlab.MergeRequestDiff) *MergeRequestChanges {
+	changes := &MergeRequestChanges{Diffs: diffs}
+	for _, diff := range diffs {
+		if diff.TooLarge || diff.Collapsed {
+			changes.Unmeasured = append(changes.Unmeasured, diff.NewPath)
+			continue
+		}
+		// diffs sent by gitlab have no file headers, only hunks, so every +/- line is a changed line
+		for _, line := range strings.Split(diff.Diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				changes.Added++
+			case strings.HasPrefix(line, "-"):
+				changes.Removed++
+			}
+		}
+	}
+	return changes
+}
+
+// Lines returns number of lines added or removed.
+func (c *MergeRequestChanges) Lines() int {
+	return c.Added + c.Removed
+}
+
+func (c *MergeRequestChanges) String() string {
+	return fmt.Sprintf("%d lines changed (+%d -%d) in %d files", c.Lines(), c.Added, c.Removed, len(c.Diffs))
+}
+
+// ChangeLimits bounds size of merge requests merged, zero values disable the corresponding limit.
+type ChangeLimits struct {
+	MaxLines int
+	MaxFiles int
+}
+
+func (l *ChangeLimits) enabled() bool {
+	return l != nil && (l.MaxLines > 0 || l.MaxFiles > 0)
+}
+
+// needsChanges tells if diff of merge requests must be read to check eligibility.
+func (a *AcceptMr) needsChanges() bool {
+	return a.ChangeLimits.enabled() || a.PathRules.enabled() || a.CodeOwners
+}
+
+// loadChanges reads diff of merge request, it is kept for the run.
+func (a *AcceptMr) loadChanges(ctx context.Context, mr *gitlab.BasicMergeRequest) error {
+	diffs, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.MergeRequestDiff, *gitlab.Response, error) {
+		return a.Client.MergeRequests.ListMergeRequestDiffs(a.ProjectName, mr.IID, &gitlab.ListMergeRequestDiffsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return fmt.Errorf("error when reading merge request changes: %s", err.Error())
+	}
+	if a.changes == nil {
+		a.changes = make(map[int64]*MergeRequestChanges)
+	}
+	a.changes[mr.IID] = newMergeRequestChanges(diffs)
+	return nil
+}
+
+func (a *AcceptMr) checkSize(mr *gitlab.BasicMergeRequest) Check {
+	changes := a.changes[mr.IID]
+	if changes == nil {
+		return failed("Size", "could not read merge request changes")
+	}
+	limits := a.ChangeLimits
+	if limits.MaxFiles > 0 && len(changes.Diffs) > limits.MaxFiles {
+		return failed("Size", fmt.Sprintf("%s, more than %d files", changes, limits.MaxFiles))
+	}
+	if limits.MaxLines > 0 && len(changes.Unmeasured) > 0 {
+		return failed("Size", fmt.Sprintf("%s, diff of %s is too large to be measured", changes, strings.Join(changes.Unmeasured, ", ")))
+	}
+	if limits.MaxLines > 0 && changes.Lines() > limits.MaxLines {
+		return failed("Size", fmt.Sprintf("%s, more than %d lines", changes, limits.MaxLines))
+	}
+	return passed("Size", changes.String())
+}
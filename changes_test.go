@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestNewMergeRequestChanges(t *testing.T) {
+	changes := newMergeRequestChanges([]*gitlab.MergeRequestDiff{
+		{NewPath: "go.mod", Diff: "@@ -1,3 +1,3 @@\n module foo\n-require bar v1.0.0\n+require bar v1.0.1\n"},
+		{NewPath: "new.go", NewFile: true, Diff: "@@ -0,0 +1,2 @@\n+package main\n+\n"},
+		{NewPath: "vendor.go", TooLarge: true},
+		{NewPath: "README.md", Diff: "@@ -1,2 +1,2 @@\n----\n+++x\n"},
+	})
+	assert.Equal(t, 4, changes.Added)
+	assert.Equal(t, 2, changes.Removed)
+	assert.Equal(t, []string{"vendor.go"}, changes.Unmeasured)
+	assert.Equal(t, "6 lines changed (+4 -2) in 4 files", changes.String())
+}
+
+func TestAcceptMr_checkSize(t *testing.T) {
+	mr := &gitlab.BasicMergeRequest{IID: 1}
+	acceptMr := &AcceptMr{ChangeLimits: &ChangeLimits{MaxLines: 3, MaxFiles: 2}}
+	assert.Equal(t, failed("Size", "could not read merge request changes"), acceptMr.checkSize(mr))
+
+	acceptMr.changes = map[int64]*MergeRequestChanges{1: newMergeRequestChanges([]*gitlab.MergeRequestDiff{
+		{NewPath: "go.mod", Diff: "-a\n+b\n"},
+	})}
+	assert.Equal(t, passed("Size", "2 lines changed (+1 -1) in 1 files"), acceptMr.checkSize(mr))
+
+	acceptMr.changes[1] = newMergeRequestChanges([]*gitlab.MergeRequestDiff{{NewPath: "go.mod", Diff: "-a\n+b\n+c\n+d\n"}})
+	assert.Equal(t, failed("Size", "4 lines changed (+3 -1) in 1 files, more than 3 lines"), acceptMr.checkSize(mr))
+
+	acceptMr.changes[1] = newMergeRequestChanges([]*gitlab.MergeRequestDiff{{NewPath: "a"}, {NewPath: "b"}, {NewPath: "c"}})
+	assert.Equal(t, failed("Size", "0 lines changed (+0 -0) in 3 files, more than 2 files"), acceptMr.checkSize(mr))
+
+	acceptMr.changes[1] = newMergeRequestChanges([]*gitlab.MergeRequestDiff{{NewPath: "a", Collapsed: true}})
+	assert.Equal(t, failed("Size", "0 lines changed (+0 -0) in 1 files, diff of a is too large to be measured"), acceptMr.checkSize(mr))
+}
+
+func TestAcceptMr_maxChangedLines(t *testing.T) {
+	accepted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Rewrite everything"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/diffs":
+			_, _ = w.Write([]byte(`[{"new_path": "main.go", "diff": "-a\n-b\n+c\n"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			accepted = true
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", ChangeLimits: &ChangeLimits{MaxLines: 2}}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, accepted)
+	result := acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, DecisionSkipped, result.Decision)
+	assert.Equal(t, "size check failed: 3 lines changed (+1 -2) in 1 files, more than 2 lines", result.Reason)
+}
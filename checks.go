@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Check is the result of one eligibility rule evaluated on a merge request.
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// Checks is the list of eligibility rules evaluated on a merge request, in evaluation order.
+type Checks []Check
+
+// Failed returns the first check which didn't pass or nil if merge request is eligible.
+func (c Checks) Failed() *Check {
+	for i := range c {
+		if !c[i].Passed {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+func passed(name, detail string) Check {
+	return Check{Name: name, Passed: true, Detail: detail}
+}
+
+func failed(name, detail string) Check {
+	return Check{Name: name, Passed: false, Detail: detail}
+}
+
+// checkEligibility evaluates every eligibility rule on a merge request,
+// all rules are evaluated even when one fails to be able to display them all in status note.
+func (a *AcceptMr) checkEligibility(mr *gitlab.BasicMergeRequest) Checks {
+	checks := Checks{
+		a.checkDraft(mr),
+		a.checkApprovals(mr),
+		a.checkPipeline(mr),
+		a.checkDiscussions(mr),
+		a.checkConflicts(mr),
+		a.checkLabels(mr),
+		a.checkPolicy(mr),
+	}
+	if a.ChangeLimits.enabled() {
+		checks = append(checks, a.checkSize(mr))
+	}
+	if a.PathRules.enabled() {
+		checks = append(checks, a.checkPaths(mr))
+	}
+	if a.CodeOwners {
+		checks = append(checks, a.checkCodeOwners(mr))
+	}
+	if a.DependencyPolicy.enabled() {
+		checks = append(checks, a.checkDependency(mr))
+	}
+	if a.Schedule.enabled() {
+		checks = append(checks, a.checkSchedule(mr))
+	}
+	if a.Verification.enabled() {
+		checks = append(checks, a.checkBranch(mr))
+	}
+	if a.SlashCommands {
+		checks = append(checks, a.checkCommand(mr))
+	}
+	return checks
+}
+
+func (a *AcceptMr) checkDraft(mr *gitlab.BasicMergeRequest) Check {
+	if mr.Draft {
+		return failed("Draft", "merge request is in draft")
+	}
+	return passed("Draft", "merge request is ready")
+}
+
+func (a *AcceptMr) checkApprovals(mr *gitlab.BasicMergeRequest) Check {
+	if mr.DetailedMergeStatus == "not_approved" {
+		return failed("Approvals", "merge request requires approvals")
+	}
+	return passed("Approvals", "no approval missing")
+}
+
+func (a *AcceptMr) checkPipeline(mr *gitlab.BasicMergeRequest) Check {
+	if a.OnBuildSucceed {
+		return passed("Pipeline", "merged when pipeline succeeds")
+	}
+	switch mr.DetailedMergeStatus {
+	case "ci_must_pass":
+		return failed("Pipeline", "pipeline must succeed")
+	case "ci_still_running":
+		return failed("Pipeline", "pipeline is still running")
+	}
+	return passed("Pipeline", "pipeline is not blocking")
+}
+
+func (a *AcceptMr) checkDiscussions(mr *gitlab.BasicMergeRequest) Check {
+	if mr.DetailedMergeStatus == "discussions_not_resolved" {
+		if unresolved := a.unresolvedThreads[mr.IID]; unresolved != nil && unresolved.Count > 0 {
+			return failed("Discussions", unresolved.String())
+		}
+		return failed("Discussions", "some threads are unresolved")
+	}
+	return passed("Discussions", "no blocking thread")
+}
+
+func (a *AcceptMr) checkConflicts(mr *gitlab.BasicMergeRequest) Check {
+	if mr.HasConflicts || mr.DetailedMergeStatus == "conflict" {
+		return failed("Conflicts", "merge request has conflicts")
+	}
+	if mr.DetailedMergeStatus == "need_rebase" {
+		return failed("Conflicts", "merge request must be rebased")
+	}
+	return passed("Conflicts", "no conflict")
+}
+
+func (a *AcceptMr) checkLabels(mr *gitlab.BasicMergeRequest) Check {
+	for _, label := range a.RequiredLabels {
+		if !slices.Contains(mr.Labels, label) {
+			return failed("Labels", fmt.Sprintf("label '%s' is required", label))
+		}
+	}
+	for _, label := range a.ExcludedLabels {
+		if slices.Contains(mr.Labels, label) {
+			return failed("Labels", fmt.Sprintf("label '%s' is excluded", label))
+		}
+	}
+	if len(mr.Labels) == 0 {
+		return passed("Labels", "no label")
+	}
+	return passed("Labels", strings.Join(mr.Labels, ", "))
+}
+
+func (a *AcceptMr) checkPolicy(mr *gitlab.BasicMergeRequest) Check {
+	if hasLabel(mr, BatchedLabel) {
+		return failed("Policy", "merge request is part of a batch")
+	}
+	if a.OnBuildSucceed && mr.MergeWhenPipelineSucceeds {
+		return failed("Policy", "merge request is already set to merge when pipeline succeeds")
+	}
+	return passed("Policy", "merge request can be accepted")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_checkEligibility(t *testing.T) {
+	acceptMr := &AcceptMr{
+		RequiredLabels: []string{"dependencies"},
+		ExcludedLabels: []string{"do-not-merge"},
+	}
+
+	checks := acceptMr.checkEligibility(&gitlab.BasicMergeRequest{
+		Labels: gitlab.Labels{"dependencies"},
+	})
+	assert.Nil(t, checks.Failed())
+
+	checks = acceptMr.checkEligibility(&gitlab.BasicMergeRequest{
+		Draft:  true,
+		Labels: gitlab.Labels{"dependencies"},
+	})
+	assert.Equal(t, "Draft", checks.Failed().Name)
+
+	checks = acceptMr.checkEligibility(&gitlab.BasicMergeRequest{
+		DetailedMergeStatus: "not_approved",
+		Labels:              gitlab.Labels{"dependencies", "do-not-merge"},
+	})
+	assert.Equal(t, "Approvals", checks.Failed().Name)
+	assert.Len(t, checks, 7)
+
+	checks = acceptMr.checkEligibility(&gitlab.BasicMergeRequest{
+		Labels: gitlab.Labels{"dependencies", "do-not-merge"},
+	})
+	assert.Equal(t, "Labels", checks.Failed().Name)
+	assert.Equal(t, "label 'do-not-merge' is excluded", checks.Failed().Detail)
+
+	acceptMr.OnBuildSucceed = true
+	checks = acceptMr.checkEligibility(&gitlab.BasicMergeRequest{
+		DetailedMergeStatus:       "ci_must_pass",
+		MergeWhenPipelineSucceeds: true,
+		Labels:                    gitlab.Labels{"dependencies"},
+	})
+	assert.Equal(t, "Policy", checks.Failed().Name)
+}
+
+func TestDefaultStatusTemplate(t *testing.T) {
+	tpl, err := loadStatusTemplate("")
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	err = tpl.Execute(buf, StatusNoteData{
+		MergeRequest: &gitlab.BasicMergeRequest{Title: "Bump foo"},
+		Checks:       Checks{passed("Draft", "merge request is ready"), failed("Conflicts", "merge request has conflicts")},
+		UpdatedAt:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), ":no_entry: Merge request will not be merged automatically.")
+	assert.Contains(t, buf.String(), "| Conflicts | :x: | merge request has conflicts |")
+	assert.Contains(t, buf.String(), "_Last updated: 2024-01-02 03:04:05 UTC_")
+}
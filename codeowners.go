@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// codeOwnersPaths are the locations where gitlab looks for a CODEOWNERS file, in order.
+var codeOwnersPaths = []string{"CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"}
+
+// e.g. "[Docs]", "^[Optional section]", "[Backend][2] @backend-team"
+var codeOwnersSectionHeader = regexp.MustCompile(`^(\^)?\[([^\]]+)\](?:\[(\d+)\])?\s*(.*)$`)
+
+// CodeOwnersRule gives owners of files matching a pattern, a rule without owner removes ownership.
+type CodeOwnersRule struct {
+	Pattern string
+	Owners  []string
+}
+
+// CodeOwnersSection is a section of a CODEOWNERS file, the default section has no name.
+type CodeOwnersSection struct {
+	Name          string
+	Optional      bool
+	Approvals     int
+	DefaultOwners []string
+	Rules         []CodeOwnersRule
+}
+
+// CodeOwners is a parsed CODEOWNERS file.
+type CodeOwners struct {
+	Sections []*CodeOwnersSection
+}
+
+// ownerRequirement is the approval of code owners required by a section for a file.
+type ownerRequirement struct {
+	Section   string
+	Owners    []string
+	Approvals int
+}
+
+func (r ownerRequirement) String() string {
+	owners := strings.Join(r.Owners, " ")
+	if r.Section != "" {
+		return fmt.Sprintf("[%s] %s", r.Section, owners)
+	}
+	return owners
+}
+
+// splitCodeOwnersLine splits a line on spaces, except escaped ones.
+func splitCodeOwnersLine(line string) []string {
+	const escapedSpace = "\x00"
+	fields := strings.Fields(strings.ReplaceAll(line, `\ `, escapedSpace))
+	for i := range fields {
+		fields[i] = strings.ReplaceAll(fields[i], escapedSpace, " ")
+	}
+	return fields
+}
+
+// parseCodeOwners parses a CODEOWNERS file, sections with the same name are merged as gitlab does.
+func parseCodeOwners(content string) *CodeOwners {
+	current := &CodeOwnersSection{Approvals: 1}
+	owners := &CodeOwners{Sections: []*CodeOwnersSection{current}}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := codeOwnersSectionHeader.FindStringSubmatch(line); m != nil {
+			current = owners.section(m[2])
+			current.Optional = m[1] != ""
+			if m[3] != "" {
+				current.Approvals, _ = strconv.Atoi(m[3])
+			}
+			if m[4] != "" {
+				current.DefaultOwners = splitCodeOwnersLine(m[4])
+			}
+			continue
+		}
+		fields := splitCodeOwnersLine(strings.TrimPrefix(line, `\`))
+		rule := CodeOwnersRule{Pattern: fields[0], Owners: fields[1:]}
+		if len(rule.Owners) == 0 && !strings.HasPrefix(rule.Pattern, "!") {
+			rule.Owners = current.DefaultOwners
+		}
+		current.Rules = append(current.Rules, rule)
+	}
+	return owners
+}
+
+func (c *CodeOwners) section(name string) *CodeOwnersSection {
+	for _, section := range c.Sections {
+		if section.Name != "" && strings.EqualFold(section.Name, name) {
+			return section
+		}
+	}
+	section := &CodeOwnersSection{Name: name, Approvals: 1}
+	c.Sections = append(c.Sections, section)
+	return section
+}
+
+// codeOwnersMatch tells if file matches a CODEOWNERS pattern, a pattern matching a directory
+// matches all files under it. Unlike path rules, a pattern not starting with a slash matches at any
+// level even when it has a slash in the middle, e.g. "app/models/" matches "src/app/models/user.rb".
+func codeOwnersMatch(pattern, file string) bool {
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+	return matchGlob(pattern, file) || (!strings.HasSuffix(pattern, "/") && matchGlob(pattern+"/", file))
+}
+
+// requirements returns approvals of code owners required for a file: in each mandatory section,
+// the last rule matching file applies.
+func (c *CodeOwners) requirements(file string) []ownerRequirement {
+	var requirements []ownerRequirement
+	for _, section := range c.Sections {
+		if section.Optional {
+			continue
+		}
+		var owners []string
+		for _, rule := range section.Rules {
+			if excluded, ok := strings.CutPrefix(rule.Pattern, "!"); ok {
+				if codeOwnersMatch(excluded, file) {
+					owners = nil
+				}
+				continue
+			}
+			if codeOwnersMatch(rule.Pattern, file) {
+				owners = rule.Owners
+			}
+		}
+		if len(owners) > 0 {
+			requirements = append(requirements, ownerRequirement{
+				Section:   section.Name,
+				Owners:    owners,
+				Approvals: max(section.Approvals, 1),
+			})
+		}
+	}
+	return requirements
+}
+
+// codeOwners reads CODEOWNERS file of a branch, nil is returned when branch has none. Files are kept for the run.
+func (a *AcceptMr) codeOwners(ctx context.Context, branch string) (*CodeOwners, error) {
+	if owners, ok := a.codeOwnersFiles[branch]; ok {
+		return owners, nil
+	}
+	var owners *CodeOwners
+	for _, path := range codeOwnersPaths {
+		content, resp, err := a.Client.RepositoryFiles.GetRawFile(a.ProjectName, path, &gitlab.GetRawFileOptions{
+			Ref: &branch,
+		}, gitlab.WithContext(ctx))
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when reading %s: %s", path, err.Error())
+		}
+		owners = parseCodeOwners(string(content))
+		break
+	}
+	if a.codeOwnersFiles == nil {
+		a.codeOwnersFiles = make(map[string]*CodeOwners)
+	}
+	a.codeOwnersFiles[branch] = owners
+	return owners, nil
+}
+
+// isGroupMember tells if user is a direct or inherited member of group, membership is kept for the run.
+func (a *AcceptMr) isGroupMember(ctx context.Context, group string, userID int64) bool {
+	key := fmt.Sprintf("%s/%d", group, userID)
+	if member, ok := a.groupMembership[key]; ok {
+		return member
+	}
+	members, _, err := a.Client.Groups.ListAllGroupMembers(group, &gitlab.ListGroupMembersOptions{
+		UserIDs: &[]int64{userID},
+	}, gitlab.WithContext(ctx))
+	member := err == nil && len(members) > 0
+	if a.groupMembership == nil {
+		a.groupMembership = make(map[string]bool)
+	}
+	a.groupMembership[key] = member
+	return member
+}
+
+// usernameByEmail finds username of user with given email, it is kept for the run.
+func (a *AcceptMr) usernameByEmail(ctx context.Context, email string) string {
+	if username, ok := a.emailUsers[email]; ok {
+		return username
+	}
+	username := ""
+	users, _, err := a.Client.Users.ListUsers(&gitlab.ListUsersOptions{Search: &email}, gitlab.WithContext(ctx))
+	if err == nil && len(users) == 1 {
+		username = users[0].Username
+	}
+	if a.emailUsers == nil {
+		a.emailUsers = make(map[string]string)
+	}
+	a.emailUsers[email] = username
+	return username
+}
+
+// isCodeOwner tells if user is designated by a CODEOWNERS owner: a username, a group,
+// a role (e.g. @@maintainer) or an email.
+func (a *AcceptMr) isCodeOwner(ctx context.Context, user *gitlab.BasicUser, owner string) bool {
+	if role, ok := strings.CutPrefix(owner, "@@"); ok {
+		level, err := parseAccessLevel(role)
+		return err == nil && a.accessLevel(ctx, user.ID) >= level
+	}
+	if name, ok := strings.CutPrefix(owner, "@"); ok {
+		return strings.EqualFold(name, user.Username) || a.isGroupMember(ctx, name, user.ID)
+	}
+	if strings.Contains(owner, "@") {
+		username := a.usernameByEmail(ctx, owner)
+		return username != "" && strings.EqualFold(username, user.Username)
+	}
+	return false
+}
+
+// loadCodeOwnersCheck checks that every changed file has been approved by its code owners,
+// result is kept for the run. Changes of merge request must have been loaded.
+func (a *AcceptMr) loadCodeOwnersCheck(ctx context.Context, mr *gitlab.BasicMergeRequest) error {
+	if a.ownerChecks == nil {
+		a.ownerChecks = make(map[int64]Check)
+	}
+	delete(a.ownerChecks, mr.IID)
+	changes := a.changes[mr.IID]
+	if changes == nil {
+		return nil
+	}
+	owners, err := a.codeOwners(ctx, mr.TargetBranch)
+	if err != nil {
+		return err
+	}
+	if owners == nil {
+		a.ownerChecks[mr.IID] = passed("CodeOwners", "no CODEOWNERS file")
+		return nil
+	}
+	approvals, _, err := a.Client.MergeRequestApprovals.GetConfiguration(a.ProjectName, mr.IID, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when reading approvals: %s", err.Error())
+	}
+	var missing []string
+	for _, file := range changedFiles(changes.Diffs) {
+		for _, requirement := range owners.requirements(file) {
+			approved := 0
+			for _, approver := range approvals.ApprovedBy {
+				if approver.User == nil {
+					continue
+				}
+				for _, owner := range requirement.Owners {
+					if a.isCodeOwner(ctx, approver.User, owner) {
+						approved++
+						break
+					}
+				}
+			}
+			if approved < requirement.Approvals {
+				missing = append(missing, fmt.Sprintf("%s (%s)", file, requirement))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		a.ownerChecks[mr.IID] = failed("CodeOwners", "missing approval of code owners for "+listFiles(missing))
+	} else {
+		a.ownerChecks[mr.IID] = passed("CodeOwners", "approved by code owners")
+	}
+	return nil
+}
+
+func (a *AcceptMr) checkCodeOwners(mr *gitlab.BasicMergeRequest) Check {
+	check, ok := a.ownerChecks[mr.IID]
+	if !ok {
+		return failed("CodeOwners", "could not check approvals of code owners")
+	}
+	return check
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+const testCodeOwners = `# default owners
+* @alice
+/docs/ @docs-team
+path\ with\ space.md @bob
+/vendor/
+app/models/ @erin
+
+[Backend][2] @backend
+*.go
+!*_test.go
+
+^[Optional]
+*.md @carol
+
+[backend]
+/cmd/ @dave
+`
+
+func TestParseCodeOwners(t *testing.T) {
+	owners := parseCodeOwners(testCodeOwners)
+	assert.Len(t, owners.Sections, 3)
+	backend := owners.Sections[1]
+	assert.Equal(t, "Backend", backend.Name)
+	assert.Equal(t, 2, backend.Approvals)
+	assert.Equal(t, []string{"@backend"}, backend.DefaultOwners)
+	assert.Equal(t, CodeOwnersRule{Pattern: "*.go", Owners: []string{"@backend"}}, backend.Rules[0])
+	assert.Equal(t, CodeOwnersRule{Pattern: "/cmd/", Owners: []string{"@dave"}}, backend.Rules[2])
+	assert.True(t, owners.Sections[2].Optional)
+	assert.Equal(t, CodeOwnersRule{Pattern: "path with space.md", Owners: []string{"@bob"}}, owners.Sections[0].Rules[2])
+
+	assert.Equal(t, []ownerRequirement{{Owners: []string{"@alice"}, Approvals: 1}}, owners.requirements("README.md"))
+	assert.Equal(t, []ownerRequirement{{Owners: []string{"@docs-team"}, Approvals: 1}}, owners.requirements("docs/guide/index.md"))
+	assert.Equal(t, []ownerRequirement{
+		{Owners: []string{"@alice"}, Approvals: 1},
+		{Section: "Backend", Owners: []string{"@backend"}, Approvals: 2},
+	}, owners.requirements("pkg/main.go"))
+	assert.Equal(t, []ownerRequirement{
+		{Owners: []string{"@alice"}, Approvals: 1},
+		{Section: "Backend", Owners: []string{"@dave"}, Approvals: 2},
+	}, owners.requirements("cmd/app/main.go"))
+	// excluded files and files without owner in a section require no approval from it
+	assert.Len(t, owners.requirements("pkg/main_test.go"), 1)
+	assert.Equal(t, []ownerRequirement{{Section: "Backend", Owners: []string{"@backend"}, Approvals: 2}}, owners.requirements("vendor/lib/lib.go"))
+	// relative patterns match at any level, anchored ones only from root
+	assert.Equal(t, []ownerRequirement{{Owners: []string{"@erin"}, Approvals: 1}}, owners.requirements("src/app/models/user.rb"))
+	assert.Equal(t, []ownerRequirement{{Owners: []string{"@alice"}, Approvals: 1}}, owners.requirements("src/docs/index.md"))
+}
+
+func TestAcceptMr_codeOwners(t *testing.T) {
+	approvedBy := `[{"user": {"id": 1, "username": "alice"}}]`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Deploy", "target_branch": "main"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/diffs":
+			_, _ = w.Write([]byte(`[{"old_path": "README.md", "new_path": "README.md"}, {"old_path": "deploy/app.yml", "new_path": "deploy/app.yml"}]`))
+		case "/api/v4/projects/test-project/repository/files/.gitlab/CODEOWNERS/raw":
+			assert.Equal(t, "main", r.URL.Query().Get("ref"))
+			_, _ = w.Write([]byte("* @alice\n[Ops]\ndeploy/ @ops\n"))
+		case "/api/v4/projects/test-project/merge_requests/1/approvals":
+			_, _ = w.Write([]byte(`{"approved_by": ` + approvedBy + `}`))
+		case "/api/v4/groups/ops/members/all":
+			if r.URL.Query().Get("user_ids[]") == "2" {
+				_, _ = w.Write([]byte(`[{"id": 2, "username": "bob"}]`))
+			} else {
+				_, _ = w.Write([]byte(`[]`))
+			}
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", CodeOwners: true}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	result := acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, DecisionSkipped, result.Decision)
+	assert.Equal(t, "codeowners check failed: missing approval of code owners for deploy/app.yml ([Ops] @ops)", result.Reason)
+
+	// bob is member of ops group
+	approvedBy = `[{"user": {"id": 1, "username": "alice"}}, {"user": {"id": 2, "username": "bob"}}]`
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionMerged, acceptMr.LastReport.MergeRequests[0].Decision)
+}
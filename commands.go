@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Labels recording on merge request the intent given by the last slash command.
+const (
+	IntentLabelMerge  = "accept-mr::merge"
+	IntentLabelSquash = "accept-mr::squash"
+	IntentLabelCancel = "accept-mr::cancel"
+)
+
+const slashCommand = "/accept-mr"
+
+var intentLabels = []string{IntentLabelMerge, IntentLabelSquash, IntentLabelCancel}
+
+var commandIntents = map[string]string{
+	"":       IntentLabelMerge,
+	"squash": IntentLabelSquash,
+	"cancel": IntentLabelCancel,
+}
+
+func parseAccessLevel(level string) (gitlab.AccessLevelValue, error) {
+	switch strings.ToLower(level) {
+	case "developer":
+		return gitlab.DeveloperPermissions, nil
+	case "maintainer":
+		return gitlab.MaintainerPermissions, nil
+	case "owner":
+		return gitlab.OwnerPermissions, nil
+	}
+	return 0, fmt.Errorf("unknown access level '%s', must be one of: developer, maintainer, owner", level)
+}
+
+// parseSlashCommand finds an accept-mr slash command in a note body and returns the intent label it leads to.
+// Only the last command in the note is taken into account.
+func parseSlashCommand(body string) (string, bool) {
+	intent := ""
+	found := false
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != slashCommand {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = strings.ToLower(fields[1])
+		}
+		label, ok := commandIntents[arg]
+		if !ok {
+			continue
+		}
+		intent = label
+		found = true
+	}
+	return intent, found
+}
+
+// intentLabel returns the intent label currently set on merge request.
+func intentLabel(mr *gitlab.BasicMergeRequest) string {
+	for _, label := range mr.Labels {
+		if slices.Contains(intentLabels, label) {
+			return label
+		}
+	}
+	return ""
+}
+
+// intent returns the intent given on merge request by an allowed user, as found by applySlashCommands
+// in this run. Intent labels are not trusted on their own as anyone able to label can set them.
+func (a *AcceptMr) intent(mr *gitlab.BasicMergeRequest) string {
+	return a.intents[mr.IID]
+}
+
+// accessLevel returns access level of a user on project, access is cached for the run.
+func (a *AcceptMr) accessLevel(ctx context.Context, userID int64) gitlab.AccessLevelValue {
+	if a.userAccess == nil {
+		a.userAccess = make(map[int64]gitlab.AccessLevelValue)
+	}
+	level, ok := a.userAccess[userID]
+	if !ok {
+		member, _, err := a.Client.ProjectMembers.GetInheritedProjectMember(a.ProjectName, userID, gitlab.WithContext(ctx))
+		if err == nil {
+			level = member.AccessLevel
+		}
+		a.userAccess[userID] = level
+	}
+	return level
+}
+
+// canCommand tells if a user has enough access on project to give slash commands.
+func (a *AcceptMr) canCommand(ctx context.Context, userID int64) bool {
+	return a.accessLevel(ctx, userID) >= a.CommandMinAccess
+}
+
+// labelAddedBy returns the user who added a label on merge request the last time, nil if unknown.
+func (a *AcceptMr) labelAddedBy(ctx context.Context, mr *gitlab.BasicMergeRequest, label string) (*gitlab.BasicUser, error) {
+	events, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.LabelEvent, *gitlab.Response, error) {
+		return a.Client.ResourceLabelEvents.ListMergeRequestsLabelEvents(a.ProjectName, mr.IID, &gitlab.ListLabelEventsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Action == "add" && events[i].Label.Name == label {
+			return &events[i].User, nil
+		}
+	}
+	return nil, nil
+}
+
+// trustedLabel tells if an intent label set on merge request was added by us or by a user allowed to give commands.
+func (a *AcceptMr) trustedLabel(ctx context.Context, mr *gitlab.BasicMergeRequest, label string, entry *log.Entry) (bool, error) {
+	user, err := a.labelAddedBy(ctx, mr, label)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		entry.Warnf("Ignoring label %s, user who added it is unknown", label)
+		return false, nil
+	}
+	if bot := a.botUser(ctx); (bot != nil && bot.ID == user.ID) || a.canCommand(ctx, user.ID) {
+		return true, nil
+	}
+	entry.Warnf("Ignoring label %s added by %s, user doesn't have enough access", label, user.Username)
+	return false, nil
+}
+
+// applySlashCommands looks for the most recent slash command given by an allowed user in merge request notes
+// and records its intent on merge request with a label. Without command, an intent label set by hand is
+// only taken into account if it was added by an allowed user.
+func (a *AcceptMr) applySlashCommands(ctx context.Context, mr *gitlab.BasicMergeRequest, entry *log.Entry) error {
+	if a.intents == nil {
+		a.intents = make(map[int64]string)
+	}
+	delete(a.intents, mr.IID)
+	notes, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.Note, *gitlab.Response, error) {
+		return a.Client.Notes.ListMergeRequestNotes(a.ProjectName, mr.IID, &gitlab.ListMergeRequestNotesOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			OrderBy:     gitlab.Ptr("created_at"),
+			Sort:        gitlab.Ptr("desc"),
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	intent := ""
+	for _, note := range notes {
+		if note.System {
+			continue
+		}
+		label, found := parseSlashCommand(note.Body)
+		if !found {
+			continue
+		}
+		if !a.canCommand(ctx, note.Author.ID) {
+			entry.Warnf("Ignoring command from %s, user doesn't have enough access", note.Author.Username)
+			continue
+		}
+		intent = label
+		break
+	}
+	current := intentLabel(mr)
+	// cancelling only prevents a merge, anyone can do it
+	if intent == "" && current == IntentLabelCancel {
+		intent = current
+	}
+	if intent == "" && current != "" {
+		trusted, err := a.trustedLabel(ctx, mr, current, entry)
+		if err != nil {
+			return err
+		}
+		if trusted {
+			intent = current
+		}
+	}
+	a.intents[mr.IID] = intent
+	if intent == "" || intent == current {
+		return nil
+	}
+	opt := &gitlab.UpdateMergeRequestOptions{
+		AddLabels: &gitlab.LabelOptions{intent},
+	}
+	if current != "" {
+		opt.RemoveLabels = &gitlab.LabelOptions{current}
+	}
+	_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	mr.Labels = append(slices.DeleteFunc(slices.Clone(mr.Labels), func(l string) bool {
+		return l == current
+	}), intent)
+	entry.Infof("Recorded slash command intent %s", intent)
+	return nil
+}
+
+func (a *AcceptMr) checkCommand(mr *gitlab.BasicMergeRequest) Check {
+	switch a.intent(mr) {
+	case IntentLabelCancel:
+		return failed("Command", "automatic merge cancelled with /accept-mr cancel")
+	case IntentLabelSquash:
+		return passed("Command", "merge with squash requested with /accept-mr squash")
+	case IntentLabelMerge:
+		return passed("Command", "merge requested with /accept-mr")
+	}
+	if a.RequireCommand {
+		return failed("Command", "waiting for /accept-mr command")
+	}
+	return passed("Command", "no command")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestParseSlashCommand(t *testing.T) {
+	intent, found := parseSlashCommand("looks good\n/accept-mr")
+	assert.True(t, found)
+	assert.Equal(t, IntentLabelMerge, intent)
+
+	intent, found = parseSlashCommand("/accept-mr squash")
+	assert.True(t, found)
+	assert.Equal(t, IntentLabelSquash, intent)
+
+	intent, found = parseSlashCommand("/accept-mr\n/accept-mr Cancel")
+	assert.True(t, found)
+	assert.Equal(t, IntentLabelCancel, intent)
+
+	_, found = parseSlashCommand("/accept-mr unknown")
+	assert.False(t, found)
+
+	_, found = parseSlashCommand("please run /accept-mr")
+	assert.False(t, found)
+}
+
+func TestAcceptMr_intentLabelAddedByHand(t *testing.T) {
+	labelAuthor := `{"id": 3, "username": "reporter"}`
+	var merge map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Feature", "labels": ["accept-mr::squash"]}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			_, _ = w.Write([]byte(`[]`))
+		case "/api/v4/projects/test-project/merge_requests/1/resource_label_events":
+			_, _ = w.Write([]byte(`[{"action": "add", "label": {"name": "accept-mr::squash"}, "user": ` + labelAuthor + `}]`))
+		case "/api/v4/projects/test-project/members/all/3":
+			_, _ = w.Write([]byte(`{"id": 3, "access_level": 20}`))
+		case "/api/v4/projects/test-project/members/all/4":
+			_, _ = w.Write([]byte(`{"id": 4, "access_level": 40}`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			merge = map[string]any{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&merge))
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{
+		Client:           client,
+		ProjectName:      "test-project",
+		SlashCommands:    true,
+		RequireCommand:   true,
+		CommandMinAccess: gitlab.DeveloperPermissions,
+	}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	result := acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, DecisionSkipped, result.Decision)
+	assert.Equal(t, "command check failed: waiting for /accept-mr command", result.Reason)
+
+	labelAuthor = `{"id": 4, "username": "maintainer"}`
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionMerged, acceptMr.LastReport.MergeRequests[0].Decision)
+	assert.Equal(t, true, merge["squash"])
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// DefaultConflictLabel is the label set on merge requests having conflicts.
+const DefaultConflictLabel = "conflict"
+
+// conflictNoteMarker starts the hidden html comment of the conflict note, it also records the user we
+// assigned to merge request, e.g. "<!-- accept-mr:conflict assignee=12 -->".
+const conflictNoteMarker = "<!-- accept-mr:conflict"
+
+var conflictNoteAssignee = regexp.MustCompile(`<!-- accept-mr:conflict assignee=(\d+) -->`)
+
+const defaultConflictTemplate = `:warning: {{ with .Assignee }}@{{ .Username }}, {{ end -}}
+this merge request has conflicts with ` + "`{{ .MergeRequest.TargetBranch }}`" + ` and can't be merged automatically.
+Please rebase it or resolve the conflicts, it will be merged again once they are gone.
+`
+
+// ConflictNoteData is the data given to the conflict note template.
+type ConflictNoteData struct {
+	MergeRequest *gitlab.BasicMergeRequest
+	// Assignee is the user asked to resolve conflicts, nil if nobody could be assigned.
+	Assignee *gitlab.BasicUser
+}
+
+// ConflictPolicy is the workflow applied on merge requests having conflicts: merge request is assigned
+// to its author, or to fallback user when author is a bot, the conflict label is added and a note
+// mentioning the assignee is posted. Everything is removed once conflicts are resolved.
+type ConflictPolicy struct {
+	Label    string
+	Fallback string
+	Template *template.Template
+}
+
+func (p *ConflictPolicy) enabled() bool {
+	return p != nil
+}
+
+// loadConflictTemplate parses conflict note template from file or default template if path is empty.
+func loadConflictTemplate(path string) (*template.Template, error) {
+	content := defaultConflictTemplate
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error when reading conflict template: %s", err.Error())
+		}
+		content = string(b)
+	}
+	tpl, err := template.New("conflict").Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("error when parsing conflict template: %s", err.Error())
+	}
+	return tpl, nil
+}
+
+func hasConflicts(mr *gitlab.BasicMergeRequest) bool {
+	return mr.HasConflicts || mr.DetailedMergeStatus == "conflict"
+}
+
+// conflictResolved tells if a merge request labelled as conflicting has no conflict anymore,
+// merge status must have been computed by gitlab to tell.
+func conflictResolved(mr *gitlab.BasicMergeRequest) bool {
+	if hasConflicts(mr) {
+		return false
+	}
+	return mr.DetailedMergeStatus != "unchecked" && mr.DetailedMergeStatus != "checking"
+}
+
+func (p *ConflictPolicy) label() string {
+	if p.Label == "" {
+		return DefaultConflictLabel
+	}
+	return p.Label
+}
+
+// conflictAssignee returns the user asked to resolve conflicts: author of merge request, unless it is
+// a bot or inactive, in which case the fallback user is returned.
+func (a *AcceptMr) conflictAssignee(ctx context.Context, mr *gitlab.BasicMergeRequest) (*gitlab.BasicUser, error) {
+	author := mr.Author
+	if author != nil && (author.State == "" || author.State == "active") && !a.isBotAccount(ctx, author.Username) {
+		return author, nil
+	}
+	if a.Conflicts.Fallback == "" {
+		return nil, nil
+	}
+	users, _, err := a.Client.Users.ListUsers(&gitlab.ListUsersOptions{
+		Username: gitlab.Ptr(a.Conflicts.Fallback),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error when reading user %s: %s", a.Conflicts.Fallback, err.Error())
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("user %s not found", a.Conflicts.Fallback)
+	}
+	return &gitlab.BasicUser{ID: users[0].ID, Username: users[0].Username, Name: users[0].Name}, nil
+}
+
+func assigneeIDs(mr *gitlab.BasicMergeRequest) []int64 {
+	ids := make([]int64, 0, len(mr.Assignees))
+	for _, assignee := range mr.Assignees {
+		ids = append(ids, assignee.ID)
+	}
+	return ids
+}
+
+// notifyConflict assigns merge request, adds the conflict label and posts the conflict note.
+// Nothing is done if merge request already has the conflict label.
+func (a *AcceptMr) notifyConflict(ctx context.Context, mr *gitlab.BasicMergeRequest) error {
+	label := a.Conflicts.label()
+	if hasLabel(mr, label) {
+		return nil
+	}
+	assignee, err := a.conflictAssignee(ctx, mr)
+	if err != nil {
+		return err
+	}
+	opt := &gitlab.UpdateMergeRequestOptions{AddLabels: &gitlab.LabelOptions{label}}
+	assigned := int64(0)
+	if assignee != nil && !slices.Contains(assigneeIDs(mr), assignee.ID) {
+		assigned = assignee.ID
+		opt.AssigneeIDs = gitlab.Ptr(append(assigneeIDs(mr), assignee.ID))
+	}
+	tpl := a.Conflicts.Template
+	if tpl == nil {
+		tpl, err = loadConflictTemplate("")
+		if err != nil {
+			return err
+		}
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s assignee=%d -->\n", conflictNoteMarker, assigned)
+	err = tpl.Execute(buf, ConflictNoteData{MergeRequest: mr, Assignee: assignee})
+	if err != nil {
+		return fmt.Errorf("error when rendering conflict template: %s", err.Error())
+	}
+	_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when updating merge request: %s", err.Error())
+	}
+	mr.Labels = append(slices.Clone(mr.Labels), label)
+	if assigned != 0 {
+		mr.Assignees = append(slices.Clone(mr.Assignees), assignee)
+	}
+	_, _, err = a.Client.Notes.CreateMergeRequestNote(a.ProjectName, mr.IID, &gitlab.CreateMergeRequestNoteOptions{
+		Body: gitlab.Ptr(buf.String()),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when commenting on merge request: %s", err.Error())
+	}
+	return nil
+}
+
+// clearConflict removes the conflict label, the assignee set by us and the conflict note.
+func (a *AcceptMr) clearConflict(ctx context.Context, mr *gitlab.BasicMergeRequest) error {
+	label := a.Conflicts.label()
+	note, err := a.findNote(ctx, mr, conflictNoteMarker)
+	if err != nil {
+		return fmt.Errorf("error when reading notes: %s", err.Error())
+	}
+	opt := &gitlab.UpdateMergeRequestOptions{RemoveLabels: &gitlab.LabelOptions{label}}
+	assigned := int64(0)
+	if note != nil {
+		if m := conflictNoteAssignee.FindStringSubmatch(note.Body); m != nil {
+			assigned, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+	}
+	ids := assigneeIDs(mr)
+	if assigned != 0 && slices.Contains(ids, assigned) {
+		opt.AssigneeIDs = gitlab.Ptr(slices.DeleteFunc(ids, func(id int64) bool {
+			return id == assigned
+		}))
+	}
+	_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when updating merge request: %s", err.Error())
+	}
+	mr.Labels = slices.DeleteFunc(slices.Clone(mr.Labels), func(l string) bool {
+		return l == label
+	})
+	if opt.AssigneeIDs != nil {
+		mr.Assignees = slices.DeleteFunc(slices.Clone(mr.Assignees), func(u *gitlab.BasicUser) bool {
+			return u.ID == assigned
+		})
+	}
+	if note != nil {
+		_, err = a.Client.Notes.DeleteMergeRequestNote(a.ProjectName, mr.IID, note.ID, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error when deleting conflict note: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// handleConflicts runs the conflict workflow on a merge request: notifies on conflicts and clears
+// everything once they are resolved.
+func (a *AcceptMr) handleConflicts(ctx context.Context, mr *gitlab.BasicMergeRequest, entry *log.Entry) error {
+	switch {
+	case hasConflicts(mr) && !hasLabel(mr, a.Conflicts.label()):
+		err := a.notifyConflict(ctx, mr)
+		if err != nil {
+			return err
+		}
+		entry.Info("Merge request has conflicts, author notified")
+	case hasLabel(mr, a.Conflicts.label()) && conflictResolved(mr):
+		err := a.clearConflict(ctx, mr)
+		if err != nil {
+			return err
+		}
+		entry.Info("Conflicts are resolved, conflict marker removed")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_conflictWorkflow(t *testing.T) {
+	mergeRequest := `[{"iid": 1, "title": "Bump lib", "target_branch": "main", "has_conflicts": true,
+		"author": {"id": 5, "username": "renovate-bot", "state": "active"}, "assignees": [{"id": 3}]}]`
+	notes := `[]`
+	var updates []map[string]any
+	var created []string
+	deleted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(mergeRequest))
+		case "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 10, "username": "accept-mr"}`))
+		case "/api/v4/users":
+			assert.Equal(t, "alice", r.URL.Query().Get("username"))
+			_, _ = w.Write([]byte(`[{"id": 7, "username": "alice"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1":
+			update := map[string]any{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			updates = append(updates, update)
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			if r.Method == http.MethodPost {
+				note := map[string]string{}
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&note))
+				created = append(created, note["body"])
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_, _ = w.Write([]byte(notes))
+		case "/api/v4/projects/test-project/merge_requests/1/notes/42":
+			assert.Equal(t, http.MethodDelete, r.Method)
+			deleted = true
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{
+		Client:      client,
+		ProjectName: "test-project",
+		BotAccounts: []string{"renovate-bot"},
+		Conflicts:   &ConflictPolicy{Label: "conflict", Fallback: "alice"},
+	}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionSkipped, acceptMr.LastReport.MergeRequests[0].Decision)
+	if assert.Len(t, updates, 1) && assert.Len(t, created, 1) {
+		assert.Equal(t, "conflict", updates[0]["add_labels"])
+		assert.Equal(t, []any{3.0, 7.0}, updates[0]["assignee_ids"])
+		assert.True(t, strings.HasPrefix(created[0], "<!-- accept-mr:conflict assignee=7 -->\n:warning: @alice, this merge request has conflicts with `main`"))
+	}
+
+	// conflicts are resolved
+	mergeRequest = `[{"iid": 1, "title": "Bump lib", "target_branch": "main", "detailed_merge_status": "mergeable",
+		"labels": ["conflict"], "author": {"id": 5, "username": "renovate-bot"}, "assignees": [{"id": 3}, {"id": 7}]}]`
+	notes = `[{"id": 42, "body": "<!-- accept-mr:conflict assignee=7 -->\nconflicts", "author": {"id": 10}}]`
+	updates = nil
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionMerged, acceptMr.LastReport.MergeRequests[0].Decision)
+	if assert.Len(t, updates, 1) {
+		assert.Equal(t, "conflict", updates[0]["remove_labels"])
+		assert.Equal(t, []any{3.0}, updates[0]["assignee_ids"])
+	}
+	assert.True(t, deleted)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// UpdateType is the semver level of a dependency update.
+type UpdateType string
+
+const (
+	UpdateTypePatch   UpdateType = "patch"
+	UpdateTypeMinor   UpdateType = "minor"
+	UpdateTypeMajor   UpdateType = "major"
+	UpdateTypeUnknown UpdateType = "unknown"
+)
+
+var updateTypeRanks = map[UpdateType]int{
+	UpdateTypePatch: 1,
+	UpdateTypeMinor: 2,
+	UpdateTypeMajor: 3,
+	// versions which can't be compared are considered as risky as a major update
+	UpdateTypeUnknown: 3,
+}
+
+func parseUpdateType(updateType string) (UpdateType, error) {
+	switch t := UpdateType(strings.ToLower(updateType)); t {
+	case UpdateTypePatch, UpdateTypeMinor, UpdateTypeMajor:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown update type '%s', must be one of: %s, %s, %s",
+		updateType, UpdateTypePatch, UpdateTypeMinor, UpdateTypeMajor)
+}
+
+// Dependency bots recognized from merge request source branch.
+const (
+	BotDependabot = "dependabot"
+	BotRenovate   = "renovate"
+)
+
+// DependencyUpdate is a dependency update proposed by a bot.
+type DependencyUpdate struct {
+	Bot      string
+	Package  string
+	From     string
+	To       string
+	Type     UpdateType
+	Security bool
+}
+
+var (
+	// e.g. "Bump lodash from 4.17.20 to 4.17.21 in /front", "build(deps): bump golang.org/x/net from 0.1.0 to 0.2.0"
+	dependabotTitle = regexp.MustCompile(`(?i)\b(?:bump|update) (\S+)(?: requirement)? from (\S+) to (\S+)`)
+	// e.g. "Update dependency lodash to v4.17.21", "chore(deps): update module golang.org/x/net to v0.2.0",
+	// "Update golang Docker tag to v1.22", "Update actions/checkout action to v4 [SECURITY]"
+	renovateTitle = regexp.MustCompile(`(?i)\bupdate (?:dependency |module |rust crate |\S+ orb )?(\S+)(?: docker tag| action)? to (\S+)`)
+	// renovate gives versions in a table of merge request description, e.g. "`4.17.20` -> `4.17.21`"
+	renovateVersions = regexp.MustCompile("`([^`]+)` -> `([^`]+)`")
+	versionNumbers   = regexp.MustCompile(`\d+(?:\.\d+)*`)
+)
+
+// parseDependencyUpdate recognizes merge requests opened by dependabot or renovate from their source branch
+// and reads updated package and versions from their title, nil is returned for other merge requests.
+func parseDependencyUpdate(mr *gitlab.BasicMergeRequest) *DependencyUpdate {
+	update := &DependencyUpdate{Type: UpdateTypeUnknown}
+	switch {
+	case strings.HasPrefix(mr.SourceBranch, BotDependabot+"/"):
+		update.Bot = BotDependabot
+		if m := dependabotTitle.FindStringSubmatch(mr.Title); m != nil {
+			update.Package, update.From, update.To = m[1], m[2], m[3]
+		}
+	case strings.HasPrefix(mr.SourceBranch, BotRenovate+"/"):
+		update.Bot = BotRenovate
+		if m := renovateTitle.FindStringSubmatch(mr.Title); m != nil {
+			update.Package, update.To = m[1], m[2]
+			for _, versions := range renovateVersions.FindAllStringSubmatch(mr.Description, -1) {
+				if strings.TrimPrefix(versions[2], "v") == strings.TrimPrefix(update.To, "v") {
+					update.From = versions[1]
+					break
+				}
+			}
+		}
+	default:
+		return nil
+	}
+	update.Security = strings.Contains(strings.ToLower(mr.Title), "[security]") ||
+		slices.ContainsFunc(mr.Labels, func(label string) bool {
+			return strings.EqualFold(label, "security")
+		})
+	if update.From != "" && update.To != "" {
+		update.Type = compareVersions(update.From, update.To)
+	}
+	return update
+}
+
+// compareVersions tells which semver part changed between two versions, versions may be prefixed
+// (e.g. "v1.2.3" or "~1.2") and have missing parts.
+func compareVersions(from, to string) UpdateType {
+	fromParts, ok := versionParts(from)
+	if !ok {
+		return UpdateTypeUnknown
+	}
+	toParts, ok := versionParts(to)
+	if !ok {
+		return UpdateTypeUnknown
+	}
+	switch {
+	case fromParts[0] != toParts[0]:
+		return UpdateTypeMajor
+	case fromParts[1] != toParts[1]:
+		return UpdateTypeMinor
+	}
+	return UpdateTypePatch
+}
+
+func versionParts(version string) ([3]int, bool) {
+	var parts [3]int
+	numbers := versionNumbers.FindString(version)
+	if numbers == "" {
+		return parts, false
+	}
+	for i, number := range strings.SplitN(numbers, ".", 4) {
+		if i > 2 {
+			break
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// DependencyPolicy restricts which dependency updates proposed by bots can be merged,
+// merge requests not opened by a bot are not concerned.
+type DependencyPolicy struct {
+	MaxUpdateType UpdateType
+	// AllowedPackages and DeniedPackages are package names or glob patterns (e.g. "@types/*").
+	AllowedPackages []string
+	DeniedPackages  []string
+	// SecurityOverride lets security updates be merged whatever MaxUpdateType and AllowedPackages.
+	SecurityOverride bool
+}
+
+func (p *DependencyPolicy) enabled() bool {
+	return p != nil && (p.MaxUpdateType != "" || len(p.AllowedPackages) > 0 || len(p.DeniedPackages) > 0)
+}
+
+// validate checks allowed and denied package patterns.
+func (p *DependencyPolicy) validate() error {
+	for _, pattern := range append(slices.Clone(p.AllowedPackages), p.DeniedPackages...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package pattern %q: %s", pattern, err.Error())
+		}
+	}
+	return nil
+}
+
+func matchPackage(patterns []string, pkg string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, pkg)
+		return matched || pattern == pkg
+	})
+}
+
+func (a *AcceptMr) checkDependency(mr *gitlab.BasicMergeRequest) Check {
+	update := parseDependencyUpdate(mr)
+	if update == nil {
+		return passed("Dependency", "not a dependency update")
+	}
+	policy := a.DependencyPolicy
+	if update.Package == "" {
+		return failed("Dependency", fmt.Sprintf("could not read updated package from %s merge request title", update.Bot))
+	}
+	detail := fmt.Sprintf("%s update of %s", update.Type, update.Package)
+	if update.From != "" {
+		detail += fmt.Sprintf(" from %s to %s", update.From, update.To)
+	}
+	if update.Security {
+		detail = "security " + detail
+	}
+	if matchPackage(policy.DeniedPackages, update.Package) {
+		return failed("Dependency", fmt.Sprintf("%s, package is denied", detail))
+	}
+	if update.Security && policy.SecurityOverride {
+		return passed("Dependency", detail)
+	}
+	if len(policy.AllowedPackages) > 0 && !matchPackage(policy.AllowedPackages, update.Package) {
+		return failed("Dependency", fmt.Sprintf("%s, package is not allowed", detail))
+	}
+	if policy.MaxUpdateType != "" && updateTypeRanks[update.Type] > updateTypeRanks[policy.MaxUpdateType] {
+		return failed("Dependency", fmt.Sprintf("%s, only %s updates or lower are merged", detail, policy.MaxUpdateType))
+	}
+	return passed("Dependency", detail)
+}
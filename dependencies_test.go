@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestParseDependencyUpdate(t *testing.T) {
+	tests := []struct {
+		mr       *gitlab.BasicMergeRequest
+		expected *DependencyUpdate
+	}{
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "dependabot/npm_and_yarn/front/lodash-4.17.21", Title: "Bump lodash from 4.17.20 to 4.17.21 in /front"},
+			expected: &DependencyUpdate{Bot: BotDependabot, Package: "lodash", From: "4.17.20", To: "4.17.21", Type: UpdateTypePatch},
+		},
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "dependabot/go_modules/golang.org/x/net-0.2.0", Title: "build(deps): bump golang.org/x/net from 0.1.0 to 0.2.0", Labels: []string{"Security"}},
+			expected: &DependencyUpdate{Bot: BotDependabot, Package: "golang.org/x/net", From: "0.1.0", To: "0.2.0", Type: UpdateTypeMinor, Security: true},
+		},
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "dependabot/pip/requests-approx-2.31", Title: "Update requests requirement from ~=2.28 to ~=3.0"},
+			expected: &DependencyUpdate{Bot: BotDependabot, Package: "requests", From: "~=2.28", To: "~=3.0", Type: UpdateTypeMajor},
+		},
+		{
+			mr: &gitlab.BasicMergeRequest{
+				SourceBranch: "renovate/lodash-4.x",
+				Title:        "chore(deps): update dependency lodash to v4.18.0 [SECURITY]",
+				Description:  "| Package | Change |\n|---|---|\n| [lodash](https://lodash.com) | [`4.17.21` -> `4.18.0`](https://renovatebot.com/diffs/npm/lodash/4.17.21/4.18.0) |",
+			},
+			expected: &DependencyUpdate{Bot: BotRenovate, Package: "lodash", From: "4.17.21", To: "v4.18.0", Type: UpdateTypeMinor, Security: true},
+		},
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "renovate/golang-1.x", Title: "Update golang Docker tag to v1.22"},
+			expected: &DependencyUpdate{Bot: BotRenovate, Package: "golang", To: "v1.22", Type: UpdateTypeUnknown},
+		},
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "renovate/all-minor-patch", Title: "Update all non-major dependencies"},
+			expected: &DependencyUpdate{Bot: BotRenovate, Type: UpdateTypeUnknown},
+		},
+		{
+			mr:       &gitlab.BasicMergeRequest{SourceBranch: "feature/bump", Title: "Bump lodash from 4.17.20 to 4.17.21"},
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, parseDependencyUpdate(test.mr), test.mr.Title)
+	}
+}
+
+func TestDependencyPolicy_validate(t *testing.T) {
+	assert.NoError(t, (&DependencyPolicy{AllowedPackages: []string{"@types/*", "lodash"}}).validate())
+	assert.EqualError(t, (&DependencyPolicy{DeniedPackages: []string{"[bad"}}).validate(),
+		`invalid package pattern "[bad": syntax error in pattern`)
+}
+
+func TestAcceptMr_checkDependency(t *testing.T) {
+	patch := &gitlab.BasicMergeRequest{SourceBranch: "dependabot/npm_and_yarn/lodash-4.17.21", Title: "Bump lodash from 4.17.20 to 4.17.21"}
+	major := &gitlab.BasicMergeRequest{SourceBranch: "dependabot/npm_and_yarn/lodash-5.0.0", Title: "Bump lodash from 4.17.20 to 5.0.0"}
+	securityMajor := &gitlab.BasicMergeRequest{SourceBranch: "dependabot/npm_and_yarn/lodash-5.0.0", Title: "Bump lodash from 4.17.20 to 5.0.0", Labels: []string{"security"}}
+	types := &gitlab.BasicMergeRequest{SourceBranch: "dependabot/npm_and_yarn/types/node-20.1.1", Title: "Bump @types/node from 20.1.0 to 20.1.1"}
+	human := &gitlab.BasicMergeRequest{SourceBranch: "feature", Title: "Rewrite everything"}
+
+	acceptMr := &AcceptMr{DependencyPolicy: &DependencyPolicy{MaxUpdateType: UpdateTypeMinor}}
+	assert.Equal(t, passed("Dependency", "patch update of lodash from 4.17.20 to 4.17.21"), acceptMr.checkDependency(patch))
+	assert.Equal(t, failed("Dependency", "major update of lodash from 4.17.20 to 5.0.0, only minor updates or lower are merged"), acceptMr.checkDependency(major))
+	assert.False(t, acceptMr.checkDependency(securityMajor).Passed)
+	assert.True(t, acceptMr.checkDependency(human).Passed)
+
+	acceptMr.DependencyPolicy.SecurityOverride = true
+	assert.Equal(t, passed("Dependency", "security major update of lodash from 4.17.20 to 5.0.0"), acceptMr.checkDependency(securityMajor))
+
+	acceptMr.DependencyPolicy.AllowedPackages = []string{"@types/*"}
+	assert.True(t, acceptMr.checkDependency(types).Passed)
+	assert.Equal(t, failed("Dependency", "patch update of lodash from 4.17.20 to 4.17.21, package is not allowed"), acceptMr.checkDependency(patch))
+	assert.True(t, acceptMr.checkDependency(securityMajor).Passed)
+
+	acceptMr.DependencyPolicy.DeniedPackages = []string{"lodash"}
+	assert.Equal(t, failed("Dependency", "security major update of lodash from 4.17.20 to 5.0.0, package is denied"), acceptMr.checkDependency(securityMajor))
+
+	// dependency check is only evaluated when a policy is set
+	assert.Len(t, (&AcceptMr{}).checkEligibility(patch), 7)
+	assert.Equal(t, "Dependency", acceptMr.checkEligibility(patch)[7].Name)
+}
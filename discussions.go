@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// UnresolvedThreads are the unresolved resolvable threads of a merge request.
+type UnresolvedThreads struct {
+	Count   int
+	Authors []string
+}
+
+func (u *UnresolvedThreads) String() string {
+	authors := make([]string, len(u.Authors))
+	for i, author := range u.Authors {
+		authors[i] = "@" + author
+	}
+	return fmt.Sprintf("%d unresolved thread(s) opened by %s", u.Count, strings.Join(authors, ", "))
+}
+
+// isUnresolved tells if a thread can be resolved and is not.
+func isUnresolved(discussion *gitlab.Discussion) bool {
+	return slices.ContainsFunc(discussion.Notes, func(note *gitlab.Note) bool {
+		return note.Resolvable && !note.Resolved
+	})
+}
+
+// isBotAccount tells if username is ours or one of the configured bot accounts.
+func (a *AcceptMr) isBotAccount(ctx context.Context, username string) bool {
+	if slices.ContainsFunc(a.BotAccounts, func(bot string) bool {
+		return strings.EqualFold(strings.TrimPrefix(bot, "@"), username)
+	}) {
+		return true
+	}
+	user := a.botUser(ctx)
+	return user != nil && user.Username == username
+}
+
+// isBotThread tells if every note of a thread was written by a bot account, a thread where a human
+// took part is never considered as a bot thread.
+func (a *AcceptMr) isBotThread(ctx context.Context, discussion *gitlab.Discussion) bool {
+	for _, note := range discussion.Notes {
+		if note.System {
+			continue
+		}
+		if !a.isBotAccount(ctx, note.Author.Username) {
+			return false
+		}
+	}
+	return len(discussion.Notes) > 0
+}
+
+// loadUnresolvedThreads lists unresolved threads of merge request, threads of bot accounts are resolved
+// when resolve is set. Result is kept for the run.
+func (a *AcceptMr) loadUnresolvedThreads(ctx context.Context, mr *gitlab.BasicMergeRequest, resolve bool, entry *log.Entry) (*UnresolvedThreads, error) {
+	discussions, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.Discussion, *gitlab.Response, error) {
+		return a.Client.Discussions.ListMergeRequestDiscussions(a.ProjectName, mr.IID, &gitlab.ListMergeRequestDiscussionsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when reading threads: %s", err.Error())
+	}
+	unresolved := &UnresolvedThreads{}
+	for _, discussion := range discussions {
+		if !isUnresolved(discussion) {
+			continue
+		}
+		if resolve && a.isBotThread(ctx, discussion) {
+			_, _, err := a.Client.Discussions.ResolveMergeRequestDiscussion(a.ProjectName, mr.IID, discussion.ID, &gitlab.ResolveMergeRequestDiscussionOptions{
+				Resolved: gitlab.Ptr(true),
+			}, gitlab.WithContext(ctx))
+			if err != nil {
+				return nil, fmt.Errorf("error when resolving thread: %s", err.Error())
+			}
+			entry.Infof("Resolved thread opened by @%s", discussion.Notes[0].Author.Username)
+			continue
+		}
+		unresolved.Count++
+		author := discussion.Notes[0].Author.Username
+		if !slices.Contains(unresolved.Authors, author) {
+			unresolved.Authors = append(unresolved.Authors, author)
+		}
+	}
+	if a.unresolvedThreads == nil {
+		a.unresolvedThreads = make(map[int64]*UnresolvedThreads)
+	}
+	a.unresolvedThreads[mr.IID] = unresolved
+	return unresolved, nil
+}
+
+// handleDiscussions reads unresolved threads of a merge request blocked by them and resolves bot threads
+// when configured to. Merge request is considered mergeable again when no thread is left.
+func (a *AcceptMr) handleDiscussions(ctx context.Context, mr *gitlab.BasicMergeRequest, entry *log.Entry) error {
+	if mr.DetailedMergeStatus != "discussions_not_resolved" {
+		return nil
+	}
+	unresolved, err := a.loadUnresolvedThreads(ctx, mr, a.ResolveBotThreads, entry)
+	if err != nil {
+		return err
+	}
+	if unresolved.Count == 0 {
+		// gitlab computes merge status again on merge
+		mr.DetailedMergeStatus = "unchecked"
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_unresolvedThreads(t *testing.T) {
+	var resolved []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Feature", "detailed_merge_status": "discussions_not_resolved"}]`))
+		case "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 10, "username": "accept-mr"}`))
+		case "/api/v4/projects/test-project/merge_requests/1/discussions":
+			_, _ = w.Write([]byte(`[
+				{"id": "a", "notes": [{"resolvable": true, "resolved": false, "author": {"username": "accept-mr"}}]},
+				{"id": "b", "notes": [{"resolvable": true, "resolved": false, "author": {"username": "renovate-bot"}}]},
+				{"id": "c", "notes": [{"resolvable": true, "resolved": false, "author": {"username": "accept-mr"}}, {"author": {"username": "alice"}}]},
+				{"id": "d", "notes": [{"resolvable": true, "resolved": false, "author": {"username": "bob"}}]},
+				{"id": "e", "notes": [{"resolvable": true, "resolved": true, "author": {"username": "bob"}}]},
+				{"id": "f", "individual_note": true, "notes": [{"resolvable": false, "author": {"username": "bob"}}]}
+			]`))
+		case "/api/v4/projects/test-project/merge_requests/1/discussions/a",
+			"/api/v4/projects/test-project/merge_requests/1/discussions/b":
+			assert.Equal(t, http.MethodPut, r.Method)
+			resolved = append(resolved, r.URL.Path[len(r.URL.Path)-1:])
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	result := acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, DecisionSkipped, result.Decision)
+	assert.Equal(t, "discussions check failed: 4 unresolved thread(s) opened by @accept-mr, @renovate-bot, @bob", result.Reason)
+	assert.Empty(t, resolved)
+
+	// human threads are never resolved, even when a bot opened them
+	acceptMr.ResolveBotThreads = true
+	acceptMr.BotAccounts = []string{"@renovate-bot"}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	result = acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, "discussions check failed: 2 unresolved thread(s) opened by @accept-mr, @bob", result.Reason)
+	assert.Equal(t, []string{"a", "b"}, resolved)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Strategies applied on a merge request which could not be merged.
+const (
+	FailureStrategyNone  = "none"
+	FailureStrategyLabel = "label"
+	FailureStrategyDraft = "draft"
+)
+
+var draftPrefixRegexp = regexp.MustCompile(`(?i)^(\s*(\[draft\]|\(draft\)|draft:|draft\s-|wip:|\[wip\])\s*)+`)
+
+// checkFailureStrategy checks strategy is known and can be applied with failure label: the label is the only
+// marker of failed merge requests, without it a merge request drafted by us would never be undrafted.
+func checkFailureStrategy(strategy, label string) error {
+	switch strategy {
+	case FailureStrategyNone, FailureStrategyLabel:
+		return nil
+	case FailureStrategyDraft:
+		if label == "" {
+			return fmt.Errorf("failure strategy '%s' needs a failure label to find back merge requests to undraft", strategy)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown failure strategy '%s', must be one of: %s, %s, %s",
+		strategy, FailureStrategyNone, FailureStrategyLabel, FailureStrategyDraft)
+}
+
+// draftTitle returns title marked as draft, dropping any existing draft or wip prefix.
+func draftTitle(title string) string {
+	return "Draft: " + undraftTitle(title)
+}
+
+// undraftTitle returns title without any draft or wip prefix.
+func undraftTitle(title string) string {
+	return draftPrefixRegexp.ReplaceAllString(title, "")
+}
+
+func hasLabel(mr *gitlab.BasicMergeRequest, label string) bool {
+	return label != "" && slices.Contains(mr.Labels, label)
+}
+
+// isMergeableAgain tells if a merge request previously marked as failed can be tried again: what blocked
+// the merge must be gone. Draft status is not a signal, it hides every other status and may have been set by us.
+// failedSHA is the head of merge request when merge failed, empty if unknown.
+func isMergeableAgain(mr *gitlab.MergeRequest, failedSHA string) bool {
+	if mr.HasConflicts {
+		return false
+	}
+	if mr.HeadPipeline != nil && mr.HeadPipeline.Status != string(gitlab.Success) && mr.HeadPipeline.Status != string(gitlab.Skipped) {
+		return false
+	}
+	// gitlab keeps merge error until next merge, it only blocks while merge request is unchanged
+	return mr.MergeError == "" || (failedSHA != "" && mr.SHA != failedSHA)
+}
+
+// isDraftNote tells if a system note marks a merge request as draft (true) or as ready (false).
+func isDraftNote(note *gitlab.Note) (draft bool, ok bool) {
+	body := strings.ToLower(note.Body)
+	switch {
+	case strings.Contains(body, "as **ready**"), strings.Contains(body, "unmarked as a **work in progress**"):
+		return false, true
+	case strings.Contains(body, "as **draft**"), strings.Contains(body, "as a **work in progress**"):
+		return true, true
+	}
+	return false, false
+}
+
+// draftedByUs tells if the draft status of a merge request was set by us, it is false when not known.
+func (a *AcceptMr) draftedByUs(ctx context.Context, mr *gitlab.BasicMergeRequest) (bool, error) {
+	user := a.botUser(ctx)
+	if user == nil {
+		return false, nil
+	}
+	notes, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.Note, *gitlab.Response, error) {
+		return a.Client.Notes.ListMergeRequestNotes(a.ProjectName, mr.IID, &gitlab.ListMergeRequestNotesOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			OrderBy:     gitlab.Ptr("created_at"),
+			Sort:        gitlab.Ptr("desc"),
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, note := range notes {
+		if !note.System {
+			continue
+		}
+		if draft, ok := isDraftNote(note); ok {
+			return draft && note.Author.ID == user.ID, nil
+		}
+	}
+	return false, nil
+}
+
+// markFailed applies the failure strategy on a merge request, the failure label is used as marker
+// to know that draft status has been set by us and can be removed later.
+func (a *AcceptMr) markFailed(ctx context.Context, mr *gitlab.BasicMergeRequest) error {
+	if a.FailureStrategy == FailureStrategyNone || a.FailureStrategy == "" {
+		return nil
+	}
+	opt := &gitlab.UpdateMergeRequestOptions{}
+	needUpdate := false
+	if a.FailureLabel != "" && !hasLabel(mr, a.FailureLabel) {
+		opt.AddLabels = &gitlab.LabelOptions{a.FailureLabel}
+		needUpdate = true
+	}
+	if a.FailureStrategy == FailureStrategyDraft && !mr.Draft {
+		opt.Title = gitlab.Ptr(draftTitle(mr.Title))
+		needUpdate = true
+	}
+	if !needUpdate {
+		return nil
+	}
+	_, _, err := a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if opt.AddLabels != nil {
+		mr.Labels = append(slices.Clone(mr.Labels), a.FailureLabel)
+	}
+	if opt.Title != nil {
+		mr.Title = *opt.Title
+		mr.Draft = true
+	}
+	return nil
+}
+
+// clearFailed removes failure marker and draft status set by us when merge request can be merged again.
+// It returns true if merge request has been updated and can be processed.
+func (a *AcceptMr) clearFailed(ctx context.Context, mr *gitlab.BasicMergeRequest) (bool, error) {
+	if !hasLabel(mr, a.FailureLabel) {
+		return false, nil
+	}
+	detail, _, err := a.Client.MergeRequests.GetMergeRequest(a.ProjectName, mr.IID, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	failedSHA := ""
+	if state := a.mergeRequestState(mr.IID); state != nil {
+		if failure := state.lastFailure(); failure != nil {
+			failedSHA = failure.SHA
+		}
+	}
+	if !isMergeableAgain(detail, failedSHA) {
+		return false, nil
+	}
+	opt := &gitlab.UpdateMergeRequestOptions{
+		RemoveLabels: &gitlab.LabelOptions{a.FailureLabel},
+	}
+	title := mr.Title
+	if mr.Draft {
+		drafted, err := a.draftedByUs(ctx, mr)
+		if err != nil {
+			return false, err
+		}
+		if drafted {
+			title = undraftTitle(mr.Title)
+			opt.Title = &title
+		}
+	}
+	_, _, err = a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, mr.IID, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if opt.Title != nil {
+		mr.Title = title
+		mr.Draft = false
+	}
+	mr.Labels = slices.DeleteFunc(slices.Clone(mr.Labels), func(l string) bool {
+		return l == a.FailureLabel
+	})
+	return true, nil
+}
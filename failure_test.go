@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestCheckFailureStrategy(t *testing.T) {
+	assert.NoError(t, checkFailureStrategy(FailureStrategyDraft, "automerge-failed"))
+	assert.NoError(t, checkFailureStrategy(FailureStrategyLabel, "automerge-failed"))
+	assert.NoError(t, checkFailureStrategy(FailureStrategyNone, ""))
+	assert.Error(t, checkFailureStrategy(FailureStrategyDraft, ""))
+	assert.Error(t, checkFailureStrategy("close", "automerge-failed"))
+}
+
+func TestDraftTitle(t *testing.T) {
+	assert.Equal(t, "Draft: Bump foo", draftTitle("Bump foo"))
+	assert.Equal(t, "Draft: Bump foo", draftTitle("WIP: Bump foo"))
+	assert.Equal(t, "Draft: Bump foo", draftTitle("WIP: WIP: Draft: Bump foo"))
+	assert.Equal(t, "Draft: Bump foo", draftTitle("[Draft] Bump foo"))
+}
+
+func TestUndraftTitle(t *testing.T) {
+	assert.Equal(t, "Bump foo", undraftTitle("Draft: WIP: Bump foo"))
+	assert.Equal(t, "Bump foo", undraftTitle("Bump foo"))
+	assert.Equal(t, "Bump draft: foo", undraftTitle("Bump draft: foo"))
+}
+
+func TestIsMergeableAgain(t *testing.T) {
+	assert.True(t, isMergeableAgain(&gitlab.MergeRequest{}, ""))
+	assert.False(t, isMergeableAgain(&gitlab.MergeRequest{BasicMergeRequest: gitlab.BasicMergeRequest{HasConflicts: true}}, ""))
+	assert.False(t, isMergeableAgain(&gitlab.MergeRequest{HeadPipeline: &gitlab.Pipeline{Status: "failed"}}, ""))
+	assert.True(t, isMergeableAgain(&gitlab.MergeRequest{HeadPipeline: &gitlab.Pipeline{Status: "success"}}, ""))
+
+	failed := &gitlab.MergeRequest{BasicMergeRequest: gitlab.BasicMergeRequest{SHA: "abc"}, MergeError: "hook declined"}
+	assert.False(t, isMergeableAgain(failed, ""))
+	assert.False(t, isMergeableAgain(failed, "abc"))
+	assert.True(t, isMergeableAgain(failed, "old"))
+}
+
+func TestAcceptMr_clearFailed(t *testing.T) {
+	draftAuthor := 10
+	var update map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 10}`))
+		case "/api/v4/projects/test-project/merge_requests/1":
+			if r.Method == http.MethodPut {
+				update = map[string]any{}
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			}
+			_, _ = w.Write([]byte(`{"iid": 1, "sha": "abc", "head_pipeline": {"status": "success"}}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			_, _ = w.Write([]byte(`[{"system": true, "body": "marked this merge request as **draft**", "author": {"id": ` +
+				fmt.Sprint(draftAuthor) + `}}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", FailureLabel: "automerge-failed"}
+
+	mr := &gitlab.BasicMergeRequest{IID: 1, Title: "Draft: Feature", Draft: true, Labels: []string{"automerge-failed"}}
+	cleared, err := acceptMr.clearFailed(context.Background(), mr)
+	assert.NoError(t, err)
+	assert.True(t, cleared)
+	assert.Equal(t, "Feature", update["title"])
+	assert.False(t, mr.Draft)
+	assert.Empty(t, mr.Labels)
+
+	// draft set by someone else is kept
+	draftAuthor = 2
+	acceptMr.userLoaded = false
+	mr = &gitlab.BasicMergeRequest{IID: 1, Title: "Draft: Feature", Draft: true, Labels: []string{"automerge-failed"}}
+	cleared, err = acceptMr.clearFailed(context.Background(), mr)
+	assert.NoError(t, err)
+	assert.True(t, cleared)
+	assert.Nil(t, update["title"])
+	assert.True(t, mr.Draft)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Name    string           `xml:"name,attr"`
+	Tests   int              `xml:"tests,attr"`
+	Skipped int              `xml:"skipped,attr"`
+	Failure int              `xml:"failures,attr"`
+	Time    string           `xml:"time,attr"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+func junitSeconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
+
+// junitTestCaseFromResult converts a merge request result in a test case,
+// merged merge requests pass, failed ones are failures and others are skipped.
+func junitTestCaseFromResult(project string, result *MergeRequestResult) junitTestCase {
+	tc := junitTestCase{
+		Name:      fmt.Sprintf("!%d %s", result.IID, result.Title),
+		ClassName: project,
+		Time:      junitSeconds(result.DurationMs),
+		File:      result.URL,
+	}
+	switch result.Decision {
+	case DecisionSkipped, DecisionPending, DecisionDeferred, DecisionUnprocessed, DecisionQuarantined:
+		tc.Skipped = &junitMessage{Message: result.Reason}
+	case DecisionFailed:
+		tc.Failure = &junitMessage{
+			Message: result.Reason,
+			Type:    "MergeError",
+			Content: strings.Join(result.Errors, "\n"),
+		}
+	}
+	if result.Decision != DecisionFailed && len(result.Errors) > 0 {
+		tc.SystemOut = strings.Join(result.Errors, "\n")
+	}
+	return tc
+}
+
+// writeJUnit writes report as a junit xml file, each merge request considered is a test case.
+func (r *RunReport) writeJUnit(path string) error {
+	suite := junitTestSuite{
+		Name:      r.Project,
+		Time:      junitSeconds(r.DurationMs),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+	for _, result := range r.MergeRequests {
+		suite.TestCases = append(suite.TestCases, junitTestCaseFromResult(r.Project, result))
+	}
+	for _, runErr := range r.Errors {
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      "run " + r.RunID,
+			ClassName: r.Project,
+			Time:      junitSeconds(0),
+			Failure:   &junitMessage{Message: runErr, Type: "RunError"},
+		})
+	}
+	for _, tc := range suite.TestCases {
+		suite.Tests++
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+	}
+	suites := junitTestSuites{
+		Name:    "accept-mr",
+		Tests:   suite.Tests,
+		Skipped: suite.Skipped,
+		Failure: suite.Failures,
+		Time:    suite.Time,
+		Suites:  []junitTestSuite{suite},
+	}
+	b, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
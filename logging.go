@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Log formats supported by --log-format.
+const (
+	LogFormatText   = "text"
+	LogFormatJSON   = "json"
+	LogFormatLogfmt = "logfmt"
+)
+
+func newLogFormatter(format string, noColor bool) (log.Formatter, error) {
+	switch format {
+	case LogFormatText:
+		return &log.TextFormatter{
+			DisableColors: noColor,
+		}, nil
+	case LogFormatJSON:
+		return &log.JSONFormatter{}, nil
+	case LogFormatLogfmt:
+		return &log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown log format '%s', must be one of: %s, %s, %s",
+		format, LogFormatText, LogFormatJSON, LogFormatLogfmt)
+}
+
+// mrLogger returns a log entry carrying merge request fields, decision and reason are added once known.
+func (a *AcceptMr) mrLogger(result *MergeRequestResult) *log.Entry {
+	fields := log.Fields{
+		"project": a.ProjectName,
+		"iid":     result.IID,
+		"sha":     result.SHA,
+		"title":   result.Title,
+	}
+	if result.Decision != "" {
+		fields["decision"] = result.Decision
+		fields["reason"] = result.Reason
+	}
+	return log.WithFields(fields)
+}
+
+// rotatingFile is a log file rotated when it reaches max size,
+// rotated files are suffixed with .1 (most recent) to .<maxBackups> (oldest).
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+	if r.maxBackups <= 0 {
+		err = os.Remove(r.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	err = os.Rename(r.path, r.path+".1")
+	if err != nil {
+		return err
+	}
+	return r.open()
+}
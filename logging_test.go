@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accept-mr.log")
+	file, err := newRotatingFile(path, 10, 2)
+	assert.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	assertContent := func(path, expected string) {
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(b))
+	}
+	assertContent(path, "fourth\n")
+	assertContent(path+".1", "third\n")
+	assertContent(path+".2", "second\n")
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewLogFormatter(t *testing.T) {
+	for _, format := range []string{LogFormatText, LogFormatJSON, LogFormatLogfmt} {
+		_, err := newLogFormatter(format, false)
+		assert.NoError(t, err)
+	}
+	_, err := newLogFormatter("xml", false)
+	assert.Error(t, err)
+}
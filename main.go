@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -58,7 +61,31 @@ func main() {
 		},
 		cli.BoolFlag{
 			Name:  "log-json, j",
-			Usage: "Write log in json",
+			Usage: "Write log in json (same as --log-format json)",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Value: LogFormatText,
+			Usage: "Log format: text, json or logfmt",
+		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Value: "info",
+			Usage: "Log level: trace, debug, info, warn, error, fatal or panic",
+		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Usage: "Write log in this file instead of stderr",
+		},
+		cli.IntFlag{
+			Name:  "log-max-size",
+			Value: 100,
+			Usage: "Size in megabytes at which log file is rotated (0 to never rotate)",
+		},
+		cli.IntFlag{
+			Name:  "log-max-backups",
+			Value: 5,
+			Usage: "Number of rotated log files to keep",
 		},
 		cli.BoolFlag{
 			Name:  "no-color",
@@ -72,8 +99,299 @@ func main() {
 			Name:  "on-build-succeed, bs",
 			Usage: "Merge request will automatically accepted if pipeline succeeded",
 		},
+		cli.StringFlag{
+			Name:  "failure-strategy, fs",
+			Value: FailureStrategyDraft,
+			Usage: "What to do on a merge request which can't be merged: none, label (add failure label) or draft (mark as draft and add failure label)",
+		},
+		cli.StringFlag{
+			Name:  "failure-label, fl",
+			Value: "automerge-failed",
+			Usage: "Label set on merge request which can't be merged, it is removed when merge request can be merged again (empty to disable, except with draft failure strategy)",
+		},
+		cli.StringSliceFlag{
+			Name:  "required-label, rl",
+			Usage: "Only accept merge request having this label (can be set multiple times)",
+		},
+		cli.StringSliceFlag{
+			Name:  "excluded-label, xl",
+			Usage: "Never accept merge request having this label (can be set multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "status-note, sn",
+			Usage: "Maintain a note on each merge request not merged explaining every eligibility check",
+		},
+		cli.StringFlag{
+			Name:  "status-template, st",
+			Usage: "Path to a go template in markdown used to render status note (default template is used if not set)",
+		},
+		cli.BoolFlag{
+			Name:  "slash-commands, sc",
+			Usage: "Read /accept-mr, /accept-mr squash and /accept-mr cancel commands in merge request comments",
+		},
+		cli.BoolFlag{
+			Name:  "require-command, rc",
+			Usage: "Only accept merge request which received an /accept-mr command (implies slash-commands)",
+		},
+		cli.StringFlag{
+			Name:  "command-min-access",
+			Value: "developer",
+			Usage: "Minimum access level on project to give slash commands (developer, maintainer or owner)",
+		},
+		cli.StringFlag{
+			Name:  "report, r",
+			Usage: "Write a report of the run in this file",
+		},
+		cli.StringFlag{
+			Name:  "report-format",
+			Value: ReportFormatJSON,
+			Usage: "Format of the report (only json is supported)",
+		},
+		cli.StringFlag{
+			Name:  "junit",
+			Usage: "Write a junit xml report of the run in this file, each merge request is a test case",
+		},
+		cli.DurationFlag{
+			Name:  "interval, i",
+			Usage: "Run as a service accepting merge requests at this interval (e.g.: 5m), run only once if not set",
+		},
+		cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "Listen address serving prometheus metrics on /metrics (e.g.: :9090)",
+		},
+		cli.StringFlag{
+			Name:  "trace-exporter",
+			Value: TraceExporterNone,
+			Usage: "Export traces of runs and api calls: none, stdout (for debugging) or otlp (opentelemetry collector over http)",
+		},
+		cli.StringFlag{
+			Name:   "trace-endpoint",
+			Value:  "http://localhost:4318",
+			Usage:  "OpenTelemetry collector endpoint used with otlp trace exporter",
+			EnvVar: "OTEL_EXPORTER_OTLP_ENDPOINT",
+		},
+		cli.IntFlag{
+			Name:  "retry-max-attempts",
+			Value: 5,
+			Usage: "Maximum attempts of a gitlab api request on transient errors (1 to disable retries)",
+		},
+		cli.DurationFlag{
+			Name:  "retry-base-delay",
+			Value: 500 * time.Millisecond,
+			Usage: "Delay before first retry, doubled at each attempt",
+		},
+		cli.DurationFlag{
+			Name:  "retry-max-delay",
+			Value: 30 * time.Second,
+			Usage: "Maximum delay between two attempts, also bounding delays asked by gitlab with Retry-After or RateLimit-Reset headers",
+		},
+		cli.Float64Flag{
+			Name:  "retry-jitter",
+			Value: 0.2,
+			Usage: "Fraction of the delay randomly added or removed between two attempts",
+		},
+		cli.Float64Flag{
+			Name:  "max-rps",
+			Usage: "Maximum requests per second sent to gitlab api (no limit if not set), requests are also slowed down when gitlab rate limit is almost reached",
+		},
+		cli.Int64Flag{
+			Name:  "max-api-calls",
+			Usage: "Maximum requests sent to gitlab api in a run, run stops once reached and remaining merge requests are reported as unprocessed (no limit if not set)",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Maximum duration of a run (e.g.: 10m), pending api calls are cancelled and remaining merge requests are reported as unprocessed (no limit if not set)",
+		},
+		cli.DurationFlag{
+			Name:  "request-timeout",
+			Value: time.Minute,
+			Usage: "Maximum duration of a single request to gitlab api",
+		},
+		cli.IntFlag{
+			Name:  "max-merges",
+			Usage: "Maximum merge requests merged in a run, remaining eligible ones are deferred (no limit if not set)",
+		},
+		cli.IntFlag{
+			Name:  "max-merges-per-branch",
+			Usage: "Maximum merge requests merged into the same target branch in a run (no limit if not set)",
+		},
+		cli.DurationFlag{
+			Name:  "min-merge-interval",
+			Usage: "Minimum duration between two merges into the same target branch (e.g.: 30m)",
+		},
+		cli.StringSliceFlag{
+			Name:  "merge-window",
+			Usage: "Only merge inside this time window, can be repeated (e.g.: \"Mon-Fri 09:00-18:00 Europe/Paris\" or \"cron:0 9 * * 1-5;0 18 * * 1-5;Europe/Paris\")",
+		},
+		cli.StringSliceFlag{
+			Name:  "freeze-window",
+			Usage: "Never merge inside this time window, can be repeated, same format as --merge-window",
+		},
+		cli.BoolFlag{
+			Name:  "freeze-periods",
+			Usage: "Never merge during freeze periods defined on gitlab project",
+		},
+		cli.StringFlag{
+			Name:  "state-backend",
+			Value: StateBackendFile,
+			Usage: "Where history of merge requests is kept between runs (file or none)",
+		},
+		cli.StringFlag{
+			Name:  "state-file",
+			Value: "accept-mr-state.json",
+			Usage: "Path of state file when state backend is file",
+		},
+		cli.DurationFlag{
+			Name:  "state-retention",
+			Value: 30 * 24 * time.Hour,
+			Usage: "How long history of merged or closed merge requests is kept in state file (kept forever if 0)",
+		},
+		cli.IntFlag{
+			Name:  "quarantine-after",
+			Usage: "Stop retrying a merge request for a while after this number of consecutive failures with the same reason (disabled if not set)",
+		},
+		cli.DurationFlag{
+			Name:  "quarantine-delay",
+			Value: time.Hour,
+			Usage: "First quarantine duration, doubled at each new failure",
+		},
+		cli.DurationFlag{
+			Name:  "quarantine-max-delay",
+			Value: 24 * time.Hour,
+			Usage: "Maximum quarantine duration",
+		},
+		cli.StringFlag{
+			Name:  "max-update-type",
+			Usage: "Highest update type merged for dependabot and renovate merge requests: patch, minor or major (no limit if not set)",
+		},
+		cli.StringSliceFlag{
+			Name:  "allow-package",
+			Usage: "Only merge dependabot and renovate updates of this package, can be a glob pattern and be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "deny-package",
+			Usage: "Never merge dependabot and renovate updates of this package, can be a glob pattern and be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "security-override",
+			Usage: "Merge security updates whatever --max-update-type and --allow-package",
+		},
+		cli.IntFlag{
+			Name:  "max-changed-lines",
+			Usage: "Skip merge requests adding or removing more lines than this (no limit if not set)",
+		},
+		cli.IntFlag{
+			Name:  "max-changed-files",
+			Usage: "Skip merge requests changing more files than this (no limit if not set)",
+		},
+		cli.StringSliceFlag{
+			Name:  "allowed-paths",
+			Usage: "Only merge merge requests changing files matching this glob, can be repeated (e.g.: go.sum, \"deploy/\", \"charts/**/values.yaml\")",
+		},
+		cli.StringSliceFlag{
+			Name:  "forbidden-paths",
+			Usage: "Never merge merge requests changing files matching this glob, can be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "resolve-bot-threads",
+			Usage: "Resolve unresolved threads blocking merge when opened by accept-mr account or a bot account, threads where a human took part are never resolved",
+		},
+		cli.StringSliceFlag{
+			Name:  "bot-account",
+			Usage: "Username of a bot account whose threads can be resolved with --resolve-bot-threads, can be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "code-owners",
+			Usage: "Only merge merge requests approved by code owners of every changed file, as defined in CODEOWNERS file of target branch",
+		},
+		cli.BoolFlag{
+			Name:  "verify-pipeline",
+			Usage: "Verify pipeline of merge commits on target branch, state is required. Merges into a branch wait for verification of the previous one, so at most one merge request per target branch is merged by run whatever --max-merges. On failure, merge is reverted with a merge request and merges into target branch are paused until its pipeline succeeds",
+		},
+		cli.DurationFlag{
+			Name:  "verify-timeout",
+			Value: 30 * time.Minute,
+			Usage: "Maximum time waited for pipeline of merge commit with --verify-pipeline",
+		},
+		cli.DurationFlag{
+			Name:  "verify-interval",
+			Value: 30 * time.Second,
+			Usage: "Interval between checks of pipeline of merge commit with --verify-pipeline",
+		},
+		cli.BoolFlag{
+			Name:  "conflict-workflow",
+			Usage: "Assign merge requests having conflicts to their author, mention them in a note and add conflict label, everything is removed once conflicts are resolved",
+		},
+		cli.StringFlag{
+			Name:  "conflict-label",
+			Value: DefaultConflictLabel,
+			Usage: "Label added by --conflict-workflow on merge requests having conflicts",
+		},
+		cli.StringFlag{
+			Name:  "conflict-assignee",
+			Usage: "Username assigned by --conflict-workflow when author of merge request is a bot or is inactive",
+		},
+		cli.StringFlag{
+			Name:  "conflict-template",
+			Usage: "Path to a go template in markdown used to render conflict note (default template is used if not set)",
+		},
 	}
 	app.Action = acceptMrAction
+	app.Commands = []cli.Command{
+		{
+			Name:   "batch",
+			Usage:  "Combine dependabot and renovate merge requests targeting the same branch into one merge request",
+			Action: batchAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "branch-prefix",
+					Value: "accept-mr/batch/",
+					Usage: "Prefix of batch branches, target branch is appended to it",
+				},
+				cli.IntFlag{
+					Name:  "min-size",
+					Value: 2,
+					Usage: "Minimum number of merge requests to create a batch",
+				},
+				cli.IntFlag{
+					Name:  "max-size",
+					Value: 20,
+					Usage: "Maximum number of merge requests in a batch (no limit if 0)",
+				},
+			},
+		},
+		{
+			Name:   "revert",
+			Usage:  "Revert merges made by accept-mr, selected by merge request, run id or time range",
+			Action: revertAction,
+			Flags: []cli.Flag{
+				cli.Int64SliceFlag{
+					Name:  "mr",
+					Usage: "IID of a merged merge request to revert, can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "run-id",
+					Usage: "Revert every merge made by run with this id, as given in run report",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "Revert every merge made at or after this time (RFC3339, e.g. 2024-05-01T10:00:00Z)",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "Revert every merge made before this time (RFC3339), used with --since or alone",
+				},
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "Reason of revert written in notes of reverted merge requests",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only log merges which would be reverted",
+				},
+			},
+		},
+	}
 	err := app.Run(os.Args)
 	if err != nil {
 		log.Fatal(err)
@@ -89,9 +407,38 @@ func checkRequired(c *cli.Context) error {
 	if c.GlobalString("project") == "" {
 		return fmt.Errorf("gitlab project can't be empty set with --project or GITLAB_PROJECT env var")
 	}
+	if err := checkFailureStrategy(c.GlobalString("failure-strategy"), c.GlobalString("failure-label")); err != nil {
+		return err
+	}
+	if _, err := parseAccessLevel(c.GlobalString("command-min-access")); err != nil {
+		return err
+	}
+	if err := checkReportFormat(c.GlobalString("report-format")); err != nil {
+		return err
+	}
+	if err := checkTraceExporter(c.GlobalString("trace-exporter")); err != nil {
+		return err
+	}
+	if err := checkStateBackend(c.GlobalString("state-backend")); err != nil {
+		return err
+	}
+	if c.GlobalString("max-update-type") != "" {
+		if _, err := parseUpdateType(c.GlobalString("max-update-type")); err != nil {
+			return err
+		}
+	}
+	if c.GlobalInt("quarantine-after") > 0 && c.GlobalString("state-backend") == StateBackendNone {
+		return fmt.Errorf("quarantine needs a state backend to remember failures")
+	}
+	if c.GlobalBool("verify-pipeline") && c.GlobalString("state-backend") == StateBackendNone {
+		return fmt.Errorf("pipeline verification needs a state backend to remember merges to verify")
+	}
+	if c.GlobalBool("verify-pipeline") && c.GlobalDuration("verify-interval") <= 0 {
+		return fmt.Errorf("verify interval must be positive")
+	}
 	return nil
 }
-func loadClient(c *cli.Context) (*gitlab.Client, error) {
+func loadClient(c *cli.Context, metrics *Metrics, tracer *Tracer, retryPolicy *RetryPolicy, limiter *APILimiter) (*gitlab.Client, error) {
 
 	token := c.GlobalString("token")
 	url := c.GlobalString("url")
@@ -113,24 +460,101 @@ func loadClient(c *cli.Context) (*gitlab.Client, error) {
 	if !strings.HasSuffix(url, "/api/v4") {
 		url = strings.TrimSuffix(url, "/") + "/api/v4"
 	}
-	git, err := gitlab.NewClient(token, gitlab.WithBaseURL(url), gitlab.WithHTTPClient(&http.Client{Transport: transport}))
+	var roundTripper http.RoundTripper = transport
+	if metrics != nil {
+		roundTripper = &metricsTransport{next: roundTripper, metrics: metrics}
+	}
+	if tracer != nil {
+		roundTripper = &tracingTransport{next: roundTripper, tracer: tracer}
+	}
+	roundTripper = &rateLimitTransport{next: roundTripper, limiter: limiter}
+	options := []gitlab.ClientOptionFunc{
+		gitlab.WithBaseURL(url),
+		gitlab.WithHTTPClient(&http.Client{Transport: roundTripper, Timeout: c.GlobalDuration("request-timeout")}),
+	}
+	options = append(options, retryPolicy.ClientOptions()...)
+	git, err := gitlab.NewClient(token, options...)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
 	return git, err
 }
 
-func acceptMrAction(c *cli.Context) error {
-	loadLogConfig(c)
-	err := checkRequired(c)
+// loadAcceptMr creates an AcceptMr from global flags.
+func loadAcceptMr(c *cli.Context) (*AcceptMr, error) {
+	err := loadLogConfig(c)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	client, err := loadClient(c)
+	err = checkRequired(c)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	metrics := loadMetrics(c)
+	tracer := newTracerFromExporter(c.GlobalString("trace-exporter"), c.GlobalString("trace-endpoint"))
+	retryPolicy := &RetryPolicy{
+		MaxAttempts: c.GlobalInt("retry-max-attempts"),
+		BaseDelay:   c.GlobalDuration("retry-base-delay"),
+		MaxDelay:    c.GlobalDuration("retry-max-delay"),
+		Jitter:      c.GlobalFloat64("retry-jitter"),
+	}
+	limiter := NewAPILimiter(c.GlobalFloat64("max-rps"), c.GlobalInt64("max-api-calls"))
+	client, err := loadClient(c, metrics, tracer, retryPolicy, limiter)
+	if err != nil {
+		return nil, err
+	}
+	statusTemplate, err := loadStatusTemplate(c.GlobalString("status-template"))
+	if err != nil {
+		return nil, err
 	}
-	acceptMr := &AcceptMr{
+	var conflicts *ConflictPolicy
+	if c.GlobalBool("conflict-workflow") {
+		conflictTemplate, err := loadConflictTemplate(c.GlobalString("conflict-template"))
+		if err != nil {
+			return nil, err
+		}
+		conflicts = &ConflictPolicy{
+			Label:    c.GlobalString("conflict-label"),
+			Fallback: c.GlobalString("conflict-assignee"),
+			Template: conflictTemplate,
+		}
+	}
+	var verification *Verification
+	if c.GlobalBool("verify-pipeline") {
+		verification = &Verification{
+			Timeout:  c.GlobalDuration("verify-timeout"),
+			Interval: c.GlobalDuration("verify-interval"),
+		}
+	}
+	commandMinAccess, _ := parseAccessLevel(c.GlobalString("command-min-access"))
+	maxUpdateType, _ := parseUpdateType(c.GlobalString("max-update-type"))
+	schedule, err := NewSchedule(c.GlobalStringSlice("merge-window"), c.GlobalStringSlice("freeze-window"), c.GlobalBool("freeze-periods"))
+	if err != nil {
+		return nil, err
+	}
+	state, err := newStateStore(c.GlobalString("state-backend"), c.GlobalString("state-file"), c.GlobalDuration("state-retention"))
+	if err != nil {
+		return nil, err
+	}
+	dependencyPolicy := &DependencyPolicy{
+		MaxUpdateType:    maxUpdateType,
+		AllowedPackages:  c.GlobalStringSlice("allow-package"),
+		DeniedPackages:   c.GlobalStringSlice("deny-package"),
+		SecurityOverride: c.GlobalBool("security-override"),
+	}
+	err = dependencyPolicy.validate()
+	if err != nil {
+		return nil, err
+	}
+	pathRules := &PathRules{
+		Allowed:   c.GlobalStringSlice("allowed-paths"),
+		Forbidden: c.GlobalStringSlice("forbidden-paths"),
+	}
+	err = pathRules.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &AcceptMr{
 		Client:             client,
 		Message:            c.GlobalString("message"),
 		FailOnError:        c.GlobalBool("failed-on-error"),
@@ -139,16 +563,198 @@ func acceptMrAction(c *cli.Context) error {
 		PipelineState:      c.GlobalString("pipeline-state"),
 		PipelineName:       c.GlobalString("pipeline-name"),
 		RemoveSourceBranch: c.GlobalBool("remove-source-branch"),
+		FailureStrategy:    c.GlobalString("failure-strategy"),
+		FailureLabel:       c.GlobalString("failure-label"),
+		RequiredLabels:     c.GlobalStringSlice("required-label"),
+		ExcludedLabels:     c.GlobalStringSlice("excluded-label"),
+		StatusNote:         c.GlobalBool("status-note"),
+		StatusTemplate:     statusTemplate,
+		SlashCommands:      c.GlobalBool("slash-commands") || c.GlobalBool("require-command"),
+		RequireCommand:     c.GlobalBool("require-command"),
+		CommandMinAccess:   commandMinAccess,
+		ReportFile:         c.GlobalString("report"),
+		ReportFormat:       c.GlobalString("report-format"),
+		JUnitFile:          c.GlobalString("junit"),
+		Metrics:            metrics,
+		Tracer:             tracer,
+		RetryPolicy:        retryPolicy,
+		Limiter:            limiter,
+		MergeLimits: &MergeLimits{
+			MaxMerges:          c.GlobalInt("max-merges"),
+			MaxMergesPerBranch: c.GlobalInt("max-merges-per-branch"),
+			MinInterval:        c.GlobalDuration("min-merge-interval"),
+		},
+		Schedule: schedule,
+		State:    state,
+		Quarantine: &Quarantine{
+			After:     c.GlobalInt("quarantine-after"),
+			BaseDelay: c.GlobalDuration("quarantine-delay"),
+			MaxDelay:  c.GlobalDuration("quarantine-max-delay"),
+		},
+		DependencyPolicy: dependencyPolicy,
+		ChangeLimits: &ChangeLimits{
+			MaxLines: c.GlobalInt("max-changed-lines"),
+			MaxFiles: c.GlobalInt("max-changed-files"),
+		},
+		PathRules:         pathRules,
+		CodeOwners:        c.GlobalBool("code-owners"),
+		Conflicts:         conflicts,
+		Verification:      verification,
+		ResolveBotThreads: c.GlobalBool("resolve-bot-threads"),
+		BotAccounts:       c.GlobalStringSlice("bot-account"),
+	}, nil
+}
+
+// signalContext returns a context cancelled on first SIGINT or SIGTERM, so that no new work is started
+// and work in progress is finished. Default handling is restored then, a second signal kills the process as usual.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+func acceptMrAction(c *cli.Context) error {
+	acceptMr, err := loadAcceptMr(c)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	interval := c.GlobalDuration("interval")
+	if interval <= 0 {
+		return runWithTimeout(ctx, acceptMr, c.GlobalDuration("timeout"))
+	}
+	log.Infof("Running every %s", interval)
+	for {
+		err := runWithTimeout(ctx, acceptMr, c.GlobalDuration("timeout"))
+		if err != nil {
+			log.Errorf("Run failed: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped")
+			return nil
+		case <-time.After(interval):
+		}
 	}
-	return acceptMr.Run()
 }
 
-func loadLogConfig(c *cli.Context) {
-	if c.GlobalBool("log-json") {
-		log.SetFormatter(&log.JSONFormatter{})
+func batchAction(c *cli.Context) error {
+	acceptMr, err := loadAcceptMr(c)
+	if err != nil {
+		return err
 	}
-	log.SetFormatter(&log.TextFormatter{
-		DisableColors: c.GlobalBool("no-color"),
+	ctx, stop := signalContext()
+	defer stop()
+	return traced(acceptMr, "batch", func() error {
+		return acceptMr.Batch(ctx, BatchOptions{
+			BranchPrefix: c.String("branch-prefix"),
+			MinSize:      c.Int("min-size"),
+			MaxSize:      c.Int("max-size"),
+		})
 	})
+}
+
+// traced runs a subcommand in a span named after it, spans are exported once it is done
+// as it happens at the end of a run.
+func traced(acceptMr *AcceptMr, name string, run func() error) error {
+	span := acceptMr.Tracer.Start(name)
+	span.SetAttribute("project", acceptMr.ProjectName)
+	err := run()
+	span.SetError(err)
+	span.End()
+	if flushErr := acceptMr.Tracer.Flush(); flushErr != nil {
+		log.Warnf("could not export traces: %s", flushErr.Error())
+	}
+	return err
+}
 
+func revertAction(c *cli.Context) error {
+	opt := RevertOptions{
+		IIDs:   c.Int64Slice("mr"),
+		RunID:  c.String("run-id"),
+		Reason: c.String("reason"),
+		DryRun: c.Bool("dry-run"),
+	}
+	var err error
+	if since := c.String("since"); since != "" {
+		opt.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %s", err.Error())
+		}
+	}
+	if until := c.String("until"); until != "" {
+		opt.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %s", err.Error())
+		}
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+	acceptMr, err := loadAcceptMr(c)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return traced(acceptMr, "revert", func() error {
+		return acceptMr.Revert(ctx, opt)
+	})
+}
+
+func runWithTimeout(ctx context.Context, acceptMr *AcceptMr, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return acceptMr.RunContext(ctx)
+}
+
+func loadMetrics(c *cli.Context) *Metrics {
+	addr := c.GlobalString("metrics-addr")
+	if addr == "" {
+		return nil
+	}
+	metrics := NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		log.Infof("Serving metrics on %s/metrics", addr)
+		err := http.ListenAndServe(addr, mux)
+		// merges go on without metrics, stopping here could interrupt a merge in progress
+		if err != nil {
+			log.Errorf("Failed to serve metrics, metrics are not exposed: %v", err)
+		}
+	}()
+	return metrics
+}
+
+func loadLogConfig(c *cli.Context) error {
+	level, err := log.ParseLevel(c.GlobalString("log-level"))
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	format := c.GlobalString("log-format")
+	if c.GlobalBool("log-json") {
+		format = LogFormatJSON
+	}
+	formatter, err := newLogFormatter(format, c.GlobalBool("no-color"))
+	if err != nil {
+		return err
+	}
+	log.SetFormatter(formatter)
+	if c.GlobalString("log-file") != "" {
+		file, err := newRotatingFile(c.GlobalString("log-file"), int64(c.GlobalInt("log-max-size"))*1024*1024, c.GlobalInt("log-max-backups"))
+		if err != nil {
+			return fmt.Errorf("error when opening log file: %s", err.Error())
+		}
+		log.SetOutput(file)
+	}
+	return nil
 }
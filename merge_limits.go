@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// MergeLimits bounds how many merge requests are merged, so a bad policy can't merge everything at once.
+// Zero values disable the corresponding limit.
+type MergeLimits struct {
+	MaxMerges          int
+	MaxMergesPerBranch int
+	MinInterval        time.Duration
+
+	merges       int
+	branchMerges map[string]int
+	lastMergedAt map[string]time.Time
+}
+
+// startRun resets merge counters, last merge time by branch is kept between runs of a process.
+func (l *MergeLimits) startRun() {
+	if l == nil {
+		return
+	}
+	l.merges = 0
+	l.branchMerges = make(map[string]int)
+}
+
+// check tells if a merge request can be merged now, a rule and a reason are given when it must be deferred.
+func (l *MergeLimits) check(mr *gitlab.BasicMergeRequest) (rule string, reason string, ok bool) {
+	if l == nil {
+		return "", "", true
+	}
+	if l.MaxMerges > 0 && l.merges >= l.MaxMerges {
+		return "max_merges", fmt.Sprintf("limit of %d merges per run reached", l.MaxMerges), false
+	}
+	if l.MaxMergesPerBranch > 0 && l.branchMerges[mr.TargetBranch] >= l.MaxMergesPerBranch {
+		return "max_merges_per_branch", fmt.Sprintf("limit of %d merges per run into %s reached", l.MaxMergesPerBranch, mr.TargetBranch), false
+	}
+	if last, merged := l.lastMergedAt[mr.TargetBranch]; merged && l.MinInterval > 0 {
+		if next := last.Add(l.MinInterval); time.Now().Before(next) {
+			return "merge_interval", fmt.Sprintf("last merge into %s was less than %s ago, next merge allowed at %s",
+				mr.TargetBranch, l.MinInterval, next.Format(time.RFC3339)), false
+		}
+	}
+	return "", "", true
+}
+
+// loadMerges completes last merge time by branch with merges recorded in state, so the minimum interval
+// also applies to merges made by previous processes.
+func (l *MergeLimits) loadMerges(states []*MergeRequestState) {
+	if l == nil {
+		return
+	}
+	for _, state := range states {
+		if state.MergedAt == nil || state.TargetBranch == "" {
+			continue
+		}
+		if l.lastMergedAt == nil {
+			l.lastMergedAt = make(map[string]time.Time)
+		}
+		if last, ok := l.lastMergedAt[state.TargetBranch]; !ok || state.MergedAt.After(last) {
+			l.lastMergedAt[state.TargetBranch] = *state.MergedAt
+		}
+	}
+}
+
+// merged records a merge into merge request target branch.
+func (l *MergeLimits) merged(mr *gitlab.BasicMergeRequest) {
+	if l == nil {
+		return
+	}
+	if l.branchMerges == nil {
+		l.branchMerges = make(map[string]int)
+	}
+	if l.lastMergedAt == nil {
+		l.lastMergedAt = make(map[string]time.Time)
+	}
+	l.merges++
+	l.branchMerges[mr.TargetBranch]++
+	l.lastMergedAt[mr.TargetBranch] = time.Now()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestMergeLimits(t *testing.T) {
+	mainMr := &gitlab.BasicMergeRequest{TargetBranch: "main"}
+	developMr := &gitlab.BasicMergeRequest{TargetBranch: "develop"}
+	limits := &MergeLimits{MaxMerges: 3, MaxMergesPerBranch: 2}
+	limits.startRun()
+
+	for i := 0; i < 2; i++ {
+		_, _, ok := limits.check(mainMr)
+		assert.True(t, ok)
+		limits.merged(mainMr)
+	}
+	rule, _, ok := limits.check(mainMr)
+	assert.False(t, ok)
+	assert.Equal(t, "max_merges_per_branch", rule)
+
+	_, _, ok = limits.check(developMr)
+	assert.True(t, ok)
+	limits.merged(developMr)
+	rule, reason, ok := limits.check(developMr)
+	assert.False(t, ok)
+	assert.Equal(t, "max_merges", rule)
+	assert.Equal(t, "limit of 3 merges per run reached", reason)
+
+	limits.startRun()
+	_, _, ok = limits.check(mainMr)
+	assert.True(t, ok)
+}
+
+func TestMergeLimits_minInterval(t *testing.T) {
+	mainMr := &gitlab.BasicMergeRequest{TargetBranch: "main"}
+	limits := &MergeLimits{MinInterval: time.Hour}
+	limits.startRun()
+	limits.merged(mainMr)
+
+	// last merge time is kept between runs
+	limits.startRun()
+	rule, _, ok := limits.check(mainMr)
+	assert.False(t, ok)
+	assert.Equal(t, "merge_interval", rule)
+
+	limits.lastMergedAt["main"] = time.Now().Add(-2 * time.Hour)
+	_, _, ok = limits.check(mainMr)
+	assert.True(t, ok)
+	_, _, ok = limits.check(&gitlab.BasicMergeRequest{TargetBranch: "develop"})
+	assert.True(t, ok)
+}
+
+func TestMergeLimits_loadMerges(t *testing.T) {
+	recent, old := time.Now().Add(-10*time.Minute), time.Now().Add(-3*time.Hour)
+	limits := &MergeLimits{MinInterval: time.Hour}
+	limits.startRun()
+	// merges made by a previous process
+	limits.loadMerges([]*MergeRequestState{
+		{IID: 1, TargetBranch: "main", MergedAt: &old},
+		{IID: 2, TargetBranch: "main", MergedAt: &recent},
+		{IID: 3, TargetBranch: "develop", MergedAt: &old},
+		{IID: 4, TargetBranch: "release"},
+	})
+	rule, _, ok := limits.check(&gitlab.BasicMergeRequest{TargetBranch: "main"})
+	assert.False(t, ok)
+	assert.Equal(t, "merge_interval", rule)
+	_, _, ok = limits.check(&gitlab.BasicMergeRequest{TargetBranch: "develop"})
+	assert.True(t, ok)
+	_, _, ok = limits.check(&gitlab.BasicMergeRequest{TargetBranch: "release"})
+	assert.True(t, ok)
+}
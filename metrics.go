@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics exposes what accept-mr is doing in prometheus text format, it is meant for long-running mode.
+// All methods are safe to call on a nil Metrics.
+type Metrics struct {
+	mu         sync.Mutex
+	collectors []collector
+
+	considered         *counterVec
+	mergeRequests      *counterVec
+	runs               *counterVec
+	mergeLatency       *histogramVec
+	apiRequestDuration *histogramVec
+	queueDepth         *gaugeVec
+	rateLimitRemaining *gaugeVec
+}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.considered = m.newCounterVec("accept_mr_merge_requests_considered_total",
+		"Number of merge requests considered.", "project")
+	m.mergeRequests = m.newCounterVec("accept_mr_merge_requests_total",
+		"Number of merge requests by decision and reason.", "project", "decision", "reason")
+	m.runs = m.newCounterVec("accept_mr_runs_total",
+		"Number of runs by status.", "project", "status")
+	m.mergeLatency = m.newHistogramVec("accept_mr_merge_latency_seconds",
+		"Time between merge request creation and its merge.",
+		[]float64{60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600}, "project")
+	m.apiRequestDuration = m.newHistogramVec("accept_mr_api_request_duration_seconds",
+		"Duration of requests made to gitlab api.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "method", "code")
+	m.queueDepth = m.newGaugeVec("accept_mr_queue_depth",
+		"Number of merge requests remaining to process in current run.", "project")
+	m.rateLimitRemaining = m.newGaugeVec("accept_mr_gitlab_rate_limit_remaining",
+		"Remaining requests allowed by gitlab rate limit, as given by last api response.")
+	return m
+}
+
+// ObserveResult records the decision taken on a merge request.
+func (m *Metrics) ObserveResult(project string, result *MergeRequestResult, createdAt *time.Time) {
+	if m == nil {
+		return
+	}
+	m.considered.inc(project)
+	m.mergeRequests.inc(project, string(result.Decision), result.Rule)
+	if result.Decision == DecisionMerged && createdAt != nil {
+		m.mergeLatency.observe(time.Since(*createdAt).Seconds(), project)
+	}
+}
+
+// ObserveRun records the end of a run.
+func (m *Metrics) ObserveRun(project string, err error) {
+	if m == nil {
+		return
+	}
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	m.runs.inc(project, status)
+}
+
+// SetQueueDepth sets number of merge requests remaining to process.
+func (m *Metrics) SetQueueDepth(project string, depth int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.set(float64(depth), project)
+}
+
+// ObserveAPIResponse records duration and rate limit of a gitlab api call.
+func (m *Metrics) ObserveAPIResponse(method string, resp *http.Response, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+		if remaining, err := strconv.ParseFloat(resp.Header.Get("RateLimit-Remaining"), 64); err == nil {
+			m.rateLimitRemaining.set(remaining)
+		}
+	}
+	m.apiRequestDuration.observe(duration.Seconds(), method, code)
+}
+
+// ServeHTTP writes all metrics in prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeText(w)
+}
+
+// writeText writes all metrics in prometheus text format.
+func (m *Metrics) writeText(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.collectors {
+		c.write(w)
+	}
+}
+
+// metricsTransport is an http.RoundTripper recording metrics of each request made to gitlab api.
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.metrics.ObserveAPIResponse(req.Method, resp, time.Since(start))
+	return resp, err
+}
+
+func (m *Metrics) register(c collector) {
+	m.collectors = append(m.collectors, c)
+}
+
+func (m *Metrics) newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{metricVec: newMetricVec(&m.mu, name, help, "counter", labels)}
+	m.register(c)
+	return c
+}
+
+func (m *Metrics) newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{metricVec: newMetricVec(&m.mu, name, help, "gauge", labels)}
+	m.register(g)
+	return g
+}
+
+func (m *Metrics) newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		metricVec: newMetricVec(&m.mu, name, help, "histogram", labels),
+		buckets:   buckets,
+		series:    make(map[string]*histogramSeries),
+	}
+	m.register(h)
+	return h
+}
+
+type metricVec struct {
+	mu         *sync.Mutex
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	values     map[string]float64
+	labelSets  map[string][]string
+}
+
+func newMetricVec(mu *sync.Mutex, name, help, kind string, labels []string) metricVec {
+	return metricVec{
+		mu:         mu,
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		values:     make(map[string]float64),
+		labelSets:  make(map[string][]string),
+	}
+}
+
+func (v *metricVec) key(labelValues []string) string {
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := v.labelSets[key]; !ok {
+		v.labelSets[key] = slices.Clone(labelValues)
+	}
+	return key
+}
+
+func (v *metricVec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.labelSets))
+	for k := range v.labelSets {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (v *metricVec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, v.kind)
+}
+
+func (v *metricVec) write(w io.Writer) {
+	v.writeHeader(w)
+	for _, key := range v.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, formatLabels(v.labels, v.labelSets[key]), formatValue(v.values[key]))
+	}
+}
+
+type counterVec struct {
+	metricVec
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)]++
+}
+
+type gaugeVec struct {
+	metricVec
+}
+
+func (g *gaugeVec) set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	metricVec
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.writeHeader(w)
+	bucketLabels := append(slices.Clone(h.labels), "le")
+	for _, key := range h.sortedKeys() {
+		s := h.series[key]
+		labelValues := h.labelSets[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+				formatLabels(bucketLabels, append(slices.Clone(labelValues), formatValue(bound))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+			formatLabels(bucketLabels, append(slices.Clone(labelValues), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, labelValues), s.count)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
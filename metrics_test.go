@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_writeText(t *testing.T) {
+	metrics := NewMetrics()
+	createdAt := time.Now().Add(-2 * time.Minute)
+	metrics.ObserveResult("owner/repo", &MergeRequestResult{Decision: DecisionMerged}, &createdAt)
+	metrics.ObserveResult("owner/repo", &MergeRequestResult{Decision: DecisionSkipped, Rule: "draft"}, nil)
+	metrics.SetQueueDepth("owner/repo", 3)
+	metrics.ObserveAPIResponse(http.MethodGet, &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Ratelimit-Remaining": []string{"42"}},
+	}, 200*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	metrics.writeText(buf)
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE accept_mr_merge_requests_total counter\n")
+	assert.Contains(t, out, `accept_mr_merge_requests_considered_total{project="owner/repo"} 2`)
+	assert.Contains(t, out, `accept_mr_merge_requests_total{project="owner/repo",decision="skipped",reason="draft"} 1`)
+	assert.Contains(t, out, `accept_mr_merge_latency_seconds_bucket{project="owner/repo",le="60"} 0`)
+	assert.Contains(t, out, `accept_mr_merge_latency_seconds_bucket{project="owner/repo",le="300"} 1`)
+	assert.Contains(t, out, `accept_mr_merge_latency_seconds_count{project="owner/repo"} 1`)
+	assert.Contains(t, out, `accept_mr_api_request_duration_seconds_bucket{method="GET",code="200",le="0.25"} 1`)
+	assert.Contains(t, out, `accept_mr_queue_depth{project="owner/repo"} 3`)
+	assert.Contains(t, out, "accept_mr_gitlab_rate_limit_remaining 42\n")
+}
+
+func TestMetrics_nil(t *testing.T) {
+	var metrics *Metrics
+	metrics.ObserveRun("owner/repo", nil)
+	metrics.SetQueueDepth("owner/repo", 1)
+	buf := &bytes.Buffer{}
+	metrics.writeText(buf)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// statusNoteMarker is an hidden html comment used to find back the note we posted on a merge request.
+const statusNoteMarker = "<!-- accept-mr:status -->"
+
+const defaultStatusTemplate = `### Automatic merge status
+
+{{ if .MergeError -}}
+:x: Could not merge automatically due to merge error: {{ .MergeError }}
+{{- else if .Merged -}}
+:white_check_mark: Merge request has been merged.
+{{- else if .Eligible -}}
+:hourglass: Merge request is eligible and will be merged.
+{{- else -}}
+:no_entry: Merge request will not be merged automatically.
+{{- end }}
+
+| Check | Status | Detail |
+|-------|--------|--------|
+{{ range .Checks -}}
+| {{ .Name }} | {{ if .Passed }}:white_check_mark:{{ else }}:x:{{ end }} | {{ .Detail }} |
+{{ end }}
+_Last updated: {{ .UpdatedAt.Format "2006-01-02 15:04:05 MST" }}_
+`
+
+// StatusNoteData is the data given to the status note template.
+type StatusNoteData struct {
+	MergeRequest *gitlab.BasicMergeRequest
+	Checks       Checks
+	Eligible     bool
+	Merged       bool
+	MergeError   string
+	UpdatedAt    time.Time
+}
+
+// loadStatusTemplate parses status note template from file or default template if path is empty.
+func loadStatusTemplate(path string) (*template.Template, error) {
+	content := defaultStatusTemplate
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error when reading status template: %s", err.Error())
+		}
+		content = string(b)
+	}
+	tpl, err := template.New("status").Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("error when parsing status template: %s", err.Error())
+	}
+	return tpl, nil
+}
+
+// botUser returns the user owning the token, it is only retrieved once per run.
+// A nil user is returned if it can't be retrieved, notes are then only matched on marker.
+func (a *AcceptMr) botUser(ctx context.Context) *gitlab.User {
+	if a.userLoaded {
+		return a.user
+	}
+	a.userLoaded = true
+	user, _, err := a.Client.Users.CurrentUser(gitlab.WithContext(ctx))
+	if err == nil {
+		a.user = user
+	}
+	return a.user
+}
+
+// findNote finds a note previously posted by us on a merge request with given marker.
+func (a *AcceptMr) findNote(ctx context.Context, mr *gitlab.BasicMergeRequest, marker string) (*gitlab.Note, error) {
+	notes, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.Note, *gitlab.Response, error) {
+		return a.Client.Notes.ListMergeRequestNotes(a.ProjectName, mr.IID, &gitlab.ListMergeRequestNotesOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	user := a.botUser(ctx)
+	for _, note := range notes {
+		if note.System || !strings.Contains(note.Body, marker) {
+			continue
+		}
+		if user != nil && note.Author.ID != user.ID {
+			continue
+		}
+		return note, nil
+	}
+	return nil, nil
+}
+
+// upsertStatusNote keeps a single status note per merge request, the note is updated in place
+// only when its content changes so reviewers are not notified on each run. Note is the existing status
+// note found with findNote, nil if there is none. When create is false the note is only updated if it exists.
+func (a *AcceptMr) upsertStatusNote(ctx context.Context, mr *gitlab.BasicMergeRequest, note *gitlab.Note, body string, create bool) error {
+	body = statusNoteMarker + "\n" + body
+	if note == nil && !create {
+		return nil
+	}
+	if note == nil {
+		_, _, err := a.Client.Notes.CreateMergeRequestNote(a.ProjectName, mr.IID, &gitlab.CreateMergeRequestNoteOptions{
+			Body: &body,
+		}, gitlab.WithContext(ctx))
+		return err
+	}
+	if strings.TrimSpace(note.Body) == strings.TrimSpace(body) {
+		return nil
+	}
+	_, _, err := a.Client.Notes.UpdateMergeRequestNote(a.ProjectName, mr.IID, note.ID, &gitlab.UpdateMergeRequestNoteOptions{
+		Body: &body,
+	}, gitlab.WithContext(ctx))
+	return err
+}
+
+// writeStatusNote renders status note template with eligibility checks and keeps it up to date on merge request.
+func (a *AcceptMr) writeStatusNote(ctx context.Context, mr *gitlab.BasicMergeRequest, checks Checks, merged bool, mergeError string) error {
+	tpl := a.StatusTemplate
+	if tpl == nil {
+		var err error
+		tpl, err = loadStatusTemplate("")
+		if err != nil {
+			return err
+		}
+	}
+	data := StatusNoteData{
+		MergeRequest: mr,
+		Checks:       checks,
+		Eligible:     checks.Failed() == nil,
+		Merged:       merged,
+		MergeError:   mergeError,
+	}
+	// the update time changes on every run, note is only updated when content rendered without it changes
+	stable := &bytes.Buffer{}
+	err := tpl.Execute(stable, data)
+	if err != nil {
+		return fmt.Errorf("error when rendering status template: %s", err.Error())
+	}
+	digest := fmt.Sprintf("<!-- accept-mr:digest %x -->", sha256.Sum256(stable.Bytes()))
+	note, err := a.findNote(ctx, mr, statusNoteMarker)
+	if err != nil {
+		return err
+	}
+	if note != nil && strings.Contains(note.Body, digest) {
+		return nil
+	}
+	data.UpdatedAt = time.Now()
+	buf := &bytes.Buffer{}
+	err = tpl.Execute(buf, data)
+	if err != nil {
+		return fmt.Errorf("error when rendering status template: %s", err.Error())
+	}
+	// a merged merge request don't need a new note, we only refresh the one explaining why it was not merged
+	return a.upsertStatusNote(ctx, mr, note, digest+"\n"+buf.String(), !merged)
+}
+
+// notifyMergeError explains on merge request why it could not be merged.
+func (a *AcceptMr) notifyMergeError(ctx context.Context, mr *gitlab.BasicMergeRequest, mergeError string) error {
+	if a.StatusNote {
+		return a.writeStatusNote(ctx, mr, a.checkEligibility(mr), false, mergeError)
+	}
+	note, err := a.findNote(ctx, mr, statusNoteMarker)
+	if err != nil {
+		return err
+	}
+	return a.upsertStatusNote(ctx, mr, note, "Could not merge automatically due to merge error: "+mergeError, true)
+}
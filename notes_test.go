@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_upsertStatusNote(t *testing.T) {
+	var created, updated []string
+	noteBody := statusNoteMarker + "\nCould not merge automatically due to merge error: conflict"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 1, "username": "bot"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/test-project/merge_requests/1/notes":
+			notes := []map[string]any{
+				{"id": 10, "body": noteBody, "author": map[string]any{"id": 2}},
+				{"id": 11, "body": noteBody, "author": map[string]any{"id": 1}},
+			}
+			_ = json.NewEncoder(w).Encode(notes)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`[]`))
+		case r.Method == http.MethodPost:
+			created = append(created, r.URL.Path)
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPut:
+			updated = append(updated, r.URL.Path)
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+
+	mr := &gitlab.BasicMergeRequest{IID: 1}
+	note, err := acceptMr.findNote(context.Background(), mr, statusNoteMarker)
+	assert.NoError(t, err)
+	err = acceptMr.upsertStatusNote(context.Background(), mr, note, "Could not merge automatically due to merge error: conflict", true)
+	assert.NoError(t, err)
+	assert.Empty(t, created)
+	assert.Empty(t, updated)
+
+	note, err = acceptMr.findNote(context.Background(), mr, statusNoteMarker)
+	assert.NoError(t, err)
+	err = acceptMr.upsertStatusNote(context.Background(), mr, note, "Could not merge automatically due to merge error: pipeline", true)
+	assert.NoError(t, err)
+	assert.Empty(t, created)
+	assert.Equal(t, []string{"/api/v4/projects/test-project/merge_requests/1/notes/11"}, updated)
+
+	mr = &gitlab.BasicMergeRequest{IID: 2}
+	note, err = acceptMr.findNote(context.Background(), mr, statusNoteMarker)
+	assert.NoError(t, err)
+	err = acceptMr.upsertStatusNote(context.Background(), mr, note, "Could not merge automatically due to merge error: pipeline", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/api/v4/projects/test-project/merge_requests/2/notes"}, created)
+}
+
+func TestAcceptMr_writeStatusNote(t *testing.T) {
+	var notes []map[string]any
+	updated, listed := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v4/user":
+			_, _ = w.Write([]byte(`{"id": 1, "username": "bot"}`))
+		case r.Method == http.MethodGet:
+			listed++
+			_ = json.NewEncoder(w).Encode(notes)
+		case r.Method == http.MethodPost:
+			note := map[string]any{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&note))
+			notes = append(notes, map[string]any{"id": 10, "body": note["body"], "author": map[string]any{"id": 1}})
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPut:
+			updated++
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project"}
+	mr := &gitlab.BasicMergeRequest{IID: 1}
+
+	checks := Checks{failed("Draft", "merge request is in draft")}
+	err = acceptMr.writeStatusNote(context.Background(), mr, checks, false, "")
+	assert.NoError(t, err)
+	if !assert.Len(t, notes, 1) {
+		return
+	}
+	// note written by a previous run
+	notes[0]["body"] = regexp.MustCompile(`_Last updated: .*_`).ReplaceAllString(notes[0]["body"].(string), "_Last updated: 2024-01-02 03:04:05 UTC_")
+
+	err = acceptMr.writeStatusNote(context.Background(), mr, checks, false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, updated)
+
+	checks = Checks{passed("Draft", "merge request is ready")}
+	listed = 0
+	err = acceptMr.writeStatusNote(context.Background(), mr, checks, false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	// notes are listed once per write
+	assert.Equal(t, 1, listed)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// maxListedFiles is the number of offending files named in a check detail.
+const maxListedFiles = 5
+
+// PathRules restricts files merge requests can change. Patterns are globs where `**` matches any number
+// of directories, a pattern without slash matches files at any level and a pattern ending with a slash
+// matches everything under a directory (e.g. "go.sum", "deploy/", "charts/**/values.yaml").
+type PathRules struct {
+	Allowed   []string
+	Forbidden []string
+}
+
+func (r *PathRules) enabled() bool {
+	return r != nil && (len(r.Allowed) > 0 || len(r.Forbidden) > 0)
+}
+
+// matchGlob tells if a slash separated path matches pattern.
+func matchGlob(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !strings.Contains(strings.TrimSuffix(pattern, "/**"), "/") && !strings.HasPrefix(pattern, "**") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+// validateGlobs checks patterns are well formed, matching with a malformed pattern would silently fail.
+func validateGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %s", pattern, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks allowed and forbidden patterns.
+func (r *PathRules) validate() error {
+	if err := validateGlobs(r.Allowed); err != nil {
+		return fmt.Errorf("error in allowed paths: %s", err.Error())
+	}
+	if err := validateGlobs(r.Forbidden); err != nil {
+		return fmt.Errorf("error in forbidden paths: %s", err.Error())
+	}
+	return nil
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		// patterns are validated on startup
+		matched, _ := path.Match(pattern[0], name[0])
+		if !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		return matchGlob(pattern, name)
+	})
+}
+
+// changedFiles returns paths touched by diffs, both old and new paths of renamed files.
+func changedFiles(diffs []*gitlab.MergeRequestDiff) []string {
+	files := make([]string, 0, len(diffs))
+	for _, diff := range diffs {
+		if diff.OldPath != "" && !slices.Contains(files, diff.OldPath) {
+			files = append(files, diff.OldPath)
+		}
+		if diff.NewPath != "" && !slices.Contains(files, diff.NewPath) {
+			files = append(files, diff.NewPath)
+		}
+	}
+	return files
+}
+
+func listFiles(files []string) string {
+	if len(files) <= maxListedFiles {
+		return strings.Join(files, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(files[:maxListedFiles], ", "), len(files)-maxListedFiles)
+}
+
+func (a *AcceptMr) checkPaths(mr *gitlab.BasicMergeRequest) Check {
+	changes := a.changes[mr.IID]
+	if changes == nil {
+		return failed("Paths", "could not read merge request changes")
+	}
+	var forbidden, notAllowed []string
+	for _, file := range changedFiles(changes.Diffs) {
+		if matchAnyGlob(a.PathRules.Forbidden, file) {
+			forbidden = append(forbidden, file)
+		} else if len(a.PathRules.Allowed) > 0 && !matchAnyGlob(a.PathRules.Allowed, file) {
+			notAllowed = append(notAllowed, file)
+		}
+	}
+	if len(forbidden) > 0 {
+		return failed("Paths", "forbidden files changed: "+listFiles(forbidden))
+	}
+	if len(notAllowed) > 0 {
+		return failed("Paths", "files not allowed changed: "+listFiles(notAllowed))
+	}
+	return passed("Paths", "all changed files are allowed")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"go.sum", "go.sum", true},
+		{"go.sum", "tools/go.sum", true},
+		{"go.sum", "go.sum.bak", false},
+		{"*.lock", "front/yarn.lock", true},
+		{".gitlab-ci.yml", ".gitlab-ci.yml", true},
+		{"deploy/", "deploy/app.yml", true},
+		{"deploy/", "env/deploy/prod/app.yml", true},
+		{"deploy/", "deploy.yml", false},
+		{"/deploy/", "env/deploy/app.yml", false},
+		{"/deploy/", "deploy/prod/app.yml", true},
+		{"charts/**/values.yaml", "charts/values.yaml", true},
+		{"charts/**/values.yaml", "charts/app/prod/values.yaml", true},
+		{"charts/**/values.yaml", "other/charts/app/values.yaml", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"**", "anything/at/all", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matches, matchGlob(test.pattern, test.name), "%s ~ %s", test.pattern, test.name)
+	}
+}
+
+func TestPathRules_validate(t *testing.T) {
+	assert.NoError(t, (&PathRules{Allowed: []string{"go.sum", "charts/**/values.yaml", "src/[a-z]*.go"}}).validate())
+	assert.EqualError(t, (&PathRules{Forbidden: []string{"deploy/", "src/[a-z.go"}}).validate(),
+		`error in forbidden paths: invalid pattern "src/[a-z.go": syntax error in pattern`)
+}
+
+func TestAcceptMr_checkPaths(t *testing.T) {
+	mr := &gitlab.BasicMergeRequest{IID: 1}
+	acceptMr := &AcceptMr{PathRules: &PathRules{
+		Allowed:   []string{"go.mod", "go.sum", "package-lock.json"},
+		Forbidden: []string{".gitlab-ci.yml", "deploy/"},
+	}}
+	assert.Equal(t, failed("Paths", "could not read merge request changes"), acceptMr.checkPaths(mr))
+
+	acceptMr.changes = map[int64]*MergeRequestChanges{1: {Diffs: []*gitlab.MergeRequestDiff{
+		{OldPath: "go.mod", NewPath: "go.mod"},
+		{OldPath: "go.sum", NewPath: "go.sum"},
+	}}}
+	assert.Equal(t, passed("Paths", "all changed files are allowed"), acceptMr.checkPaths(mr))
+
+	acceptMr.changes[1].Diffs = append(acceptMr.changes[1].Diffs,
+		&gitlab.MergeRequestDiff{OldPath: "main.go", NewPath: "cmd/main.go", RenamedFile: true})
+	assert.Equal(t, failed("Paths", "files not allowed changed: main.go, cmd/main.go"), acceptMr.checkPaths(mr))
+
+	acceptMr.changes[1].Diffs = append(acceptMr.changes[1].Diffs,
+		&gitlab.MergeRequestDiff{OldPath: ".gitlab-ci.yml", NewPath: ".gitlab-ci.yml"},
+		&gitlab.MergeRequestDiff{OldPath: "deploy/app.yml", NewPath: "deploy/app.yml"})
+	assert.Equal(t, failed("Paths", "forbidden files changed: .gitlab-ci.yml, deploy/app.yml"), acceptMr.checkPaths(mr))
+}
+
+func TestListFiles(t *testing.T) {
+	assert.Equal(t, "a, b", listFiles([]string{"a", "b"}))
+	assert.Equal(t, "a, b, c, d, e and 2 more", listFiles([]string{"a", "b", "c", "d", "e", "f", "g"}))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Quarantine stops retrying a merge request after After consecutive failures with the same reason,
+// for BaseDelay doubled at each new failure and bounded by MaxDelay.
+// A new push or a label change on merge request releases it early.
+// All methods are safe to call on a nil Quarantine.
+type Quarantine struct {
+	After     int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (q *Quarantine) enabled() bool {
+	return q != nil && q.After > 0
+}
+
+// delay returns quarantine duration after given number of consecutive failures.
+func (q *Quarantine) delay(failures int) time.Duration {
+	delay := time.Duration(float64(q.BaseDelay) * math.Pow(2, float64(failures-q.After)))
+	if q.MaxDelay > 0 && (delay > q.MaxDelay || delay <= 0) {
+		delay = q.MaxDelay
+	}
+	return delay
+}
+
+// check tells if merge request is still in quarantine. A merge request which changed since its last failure
+// is released and its failures are forgotten. Once quarantine expires, failures are kept so that next
+// failure quarantines it again at once for a doubled period. State is updated accordingly.
+func (q *Quarantine) check(state *MergeRequestState, mr *gitlab.BasicMergeRequest, now time.Time) (until time.Time, quarantined bool) {
+	if !q.enabled() || state == nil || state.QuarantinedUntil == nil {
+		return time.Time{}, false
+	}
+	if state.SHA != mr.SHA || !sameLabels(state.Labels, mr.Labels) {
+		state.QuarantinedUntil = nil
+		state.ConsecutiveFailures = 0
+		return time.Time{}, false
+	}
+	if !now.Before(*state.QuarantinedUntil) {
+		state.QuarantinedUntil = nil
+		return time.Time{}, false
+	}
+	return *state.QuarantinedUntil, true
+}
+
+// update quarantines merge request when it reached the consecutive failures threshold.
+func (q *Quarantine) update(state *MergeRequestState, now time.Time) *time.Time {
+	if !q.enabled() || state == nil || state.ConsecutiveFailures < q.After {
+		return nil
+	}
+	until := now.Add(q.delay(state.ConsecutiveFailures))
+	state.QuarantinedUntil = &until
+	return &until
+}
+
+func sameLabels(a, b []string) bool {
+	a = slices.Sorted(slices.Values(a))
+	b = slices.Sorted(slices.Values(b))
+	return slices.Equal(a, b)
+}
+
+// quarantineReason describes why merge request is quarantined.
+func quarantineReason(state *MergeRequestState, until time.Time) string {
+	reason := ""
+	if last := state.lastFailure(); last != nil {
+		reason = ": " + last.Reason
+	}
+	return fmt.Sprintf("quarantined after %d consecutive failures%s, next retry at %s",
+		state.ConsecutiveFailures, reason, until.Format(time.RFC3339))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestQuarantine_delay(t *testing.T) {
+	q := &Quarantine{After: 3, BaseDelay: time.Hour, MaxDelay: 6 * time.Hour}
+	assert.Equal(t, time.Hour, q.delay(3))
+	assert.Equal(t, 2*time.Hour, q.delay(4))
+	assert.Equal(t, 4*time.Hour, q.delay(5))
+	assert.Equal(t, 6*time.Hour, q.delay(6))
+	assert.Equal(t, 6*time.Hour, q.delay(100))
+}
+
+func TestQuarantine(t *testing.T) {
+	now := time.Now()
+	q := &Quarantine{After: 2, BaseDelay: time.Hour}
+	mr := &gitlab.BasicMergeRequest{IID: 1, SHA: "abc", Labels: []string{"bot", "deps"}}
+	state := &MergeRequestState{IID: 1}
+	failure := &MergeRequestResult{IID: 1, SHA: "abc", Decision: DecisionFailed, Rule: "merge_error", Reason: "boom"}
+
+	state.record("run-1", failure, mr.Labels)
+	assert.Nil(t, q.update(state, now))
+	state.record("run-2", failure, mr.Labels)
+	until := q.update(state, now)
+	assert.Equal(t, now.Add(time.Hour), *until)
+
+	checked, quarantined := q.check(state, mr, now)
+	assert.True(t, quarantined)
+	assert.Equal(t, *until, checked)
+	assert.Equal(t, "quarantined after 2 consecutive failures: boom, next retry at "+until.Format(time.RFC3339), quarantineReason(state, checked))
+	_, quarantined = q.check(state, &gitlab.BasicMergeRequest{IID: 1, SHA: "abc", Labels: []string{"deps", "bot"}}, now)
+	assert.True(t, quarantined)
+
+	// a label change releases merge request
+	_, quarantined = q.check(state, &gitlab.BasicMergeRequest{IID: 1, SHA: "abc", Labels: []string{"bot"}}, now)
+	assert.False(t, quarantined)
+	assert.Nil(t, state.QuarantinedUntil)
+	assert.Equal(t, 0, state.ConsecutiveFailures)
+
+	// a different reason restarts count
+	state.record("run-3", failure, mr.Labels)
+	state.record("run-4", &MergeRequestResult{IID: 1, SHA: "abc", Decision: DecisionFailed, Rule: "merge_error", Reason: "other"}, mr.Labels)
+	assert.Equal(t, 1, state.ConsecutiveFailures)
+	assert.Nil(t, q.update(state, now))
+
+	// period doubles when merge request fails again after quarantine expired
+	state.record("run-5", failure, mr.Labels)
+	state.record("run-6", failure, mr.Labels)
+	first := q.update(state, now)
+	assert.Equal(t, now.Add(time.Hour), *first)
+	_, quarantined = q.check(state, mr, first.Add(time.Minute))
+	assert.False(t, quarantined)
+	assert.Nil(t, state.QuarantinedUntil)
+	assert.Equal(t, 2, state.ConsecutiveFailures)
+	state.record("run-7", failure, mr.Labels)
+	second := q.update(state, first.Add(time.Minute))
+	assert.Equal(t, first.Add(time.Minute).Add(2*time.Hour), *second)
+
+	var nilQuarantine *Quarantine
+	_, quarantined = nilQuarantine.check(state, mr, now)
+	assert.False(t, quarantined)
+}
+
+func TestAcceptMr_quarantine(t *testing.T) {
+	merges := 0
+	sha := "abc"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo", "sha": "` + sha + `"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			merges++
+			_, _ = w.Write([]byte(`{"merge_error": "hook refused"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	store, err := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{
+		Client:      client,
+		ProjectName: "test-project",
+		State:       store,
+		Quarantine:  &Quarantine{After: 2, BaseDelay: time.Hour},
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, acceptMr.RunContext(context.Background()))
+	}
+	assert.Equal(t, 2, merges)
+	report := acceptMr.LastReport
+	assert.Equal(t, DecisionQuarantined, report.MergeRequests[0].Decision)
+	assert.NotNil(t, report.MergeRequests[0].NextRetryAt)
+	assert.Equal(t, 1, report.Totals.Quarantined)
+
+	// a new push releases merge request
+	sha = "def"
+	assert.NoError(t, acceptMr.RunContext(context.Background()))
+	assert.Equal(t, 3, merges)
+	assert.Equal(t, DecisionFailed, acceptMr.LastReport.MergeRequests[0].Decision)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// ErrAPIBudgetExhausted is returned for requests made after the api call budget of the run is exhausted.
+var ErrAPIBudgetExhausted = errors.New("gitlab api call budget exhausted")
+
+// slowdownThreshold is the fraction of gitlab rate limit remaining under which requests are slowed down.
+const slowdownThreshold = 0.2
+
+// APILimiter limits requests made to gitlab api with a token bucket of MaxRPS requests per second,
+// slows down when gitlab reports its rate limit is close to be reached and stops
+// sending requests once MaxCalls requests have been made in a run.
+// All methods are safe to call on a nil APILimiter.
+type APILimiter struct {
+	MaxRPS   float64
+	MaxCalls int64
+
+	limiter *rate.Limiter
+	calls   atomic.Int64
+	mu      sync.Mutex
+	slowed  bool
+}
+
+func NewAPILimiter(maxRPS float64, maxCalls int64) *APILimiter {
+	limit := rate.Inf
+	if maxRPS > 0 {
+		limit = rate.Limit(maxRPS)
+	}
+	return &APILimiter{
+		MaxRPS:   maxRPS,
+		MaxCalls: maxCalls,
+		limiter:  rate.NewLimiter(limit, int(math.Max(1, math.Ceil(maxRPS)))),
+	}
+}
+
+// Reset starts a new budget, it is called at each run.
+func (l *APILimiter) Reset() {
+	if l == nil {
+		return
+	}
+	l.calls.Store(0)
+}
+
+// Calls returns number of requests made in current run.
+func (l *APILimiter) Calls() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.calls.Load()
+}
+
+// Exhausted tells if api call budget of current run is exhausted.
+func (l *APILimiter) Exhausted() bool {
+	if l == nil || l.MaxCalls <= 0 {
+		return false
+	}
+	return l.calls.Load() >= l.MaxCalls
+}
+
+func (l *APILimiter) wait(req *http.Request) error {
+	if l.MaxCalls > 0 && l.calls.Add(1) > l.MaxCalls {
+		l.calls.Store(l.MaxCalls)
+		return ErrAPIBudgetExhausted
+	}
+	if l.MaxCalls <= 0 {
+		l.calls.Add(1)
+	}
+	return l.limiter.Wait(req.Context())
+}
+
+func (l *APILimiter) baseLimit() rate.Limit {
+	if l.MaxRPS > 0 {
+		return rate.Limit(l.MaxRPS)
+	}
+	return rate.Inf
+}
+
+// observe adapts request rate from RateLimit-* headers sent by gitlab: when remaining requests
+// are under threshold, requests are spread until rate limit reset.
+func (l *APILimiter) observe(resp *http.Response) {
+	remaining, err := strconv.ParseFloat(resp.Header.Get("RateLimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	limit, err := strconv.ParseFloat(resp.Header.Get("RateLimit-Limit"), 64)
+	if err != nil || limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if remaining/limit >= slowdownThreshold {
+		if l.slowed {
+			l.slowed = false
+			l.limiter.SetLimit(l.baseLimit())
+			log.Info("GitLab rate limit recovered, requests are no longer slowed down")
+		}
+		return
+	}
+	untilReset := time.Minute
+	if reset, err := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64); err == nil {
+		untilReset = time.Until(time.Unix(reset, 0))
+	}
+	if untilReset <= 0 {
+		return
+	}
+	slowLimit := rate.Limit(math.Max(remaining, 1) / untilReset.Seconds())
+	if slowLimit > l.baseLimit() {
+		return
+	}
+	l.limiter.SetLimit(slowLimit)
+	if !l.slowed {
+		l.slowed = true
+		log.Warnf("GitLab rate limit almost reached (%.0f/%.0f remaining), slowing down requests to %.2f/s", remaining, limit, float64(slowLimit))
+	}
+}
+
+// rateLimitTransport is an http.RoundTripper applying an APILimiter on each request made to gitlab api.
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *APILimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiter.wait(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if resp != nil {
+		t.limiter.observe(resp)
+	}
+	return resp, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"golang.org/x/time/rate"
+)
+
+func TestAcceptMr_RunAPIBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo"}, {"iid": 2, "title": "Bump bar"}, {"iid": 3, "title": "Bump baz"}]`))
+		default:
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		}
+	}))
+	defer ts.Close()
+	limiter := NewAPILimiter(0, 2)
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL), gitlab.WithHTTPClient(&http.Client{
+		Transport: &rateLimitTransport{next: http.DefaultTransport, limiter: limiter},
+	}))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", FailOnError: true, Limiter: limiter}
+	err = acceptMr.Run()
+	assert.NoError(t, err)
+	report := acceptMr.LastReport
+	assert.Equal(t, DecisionMerged, report.MergeRequests[0].Decision)
+	assert.Equal(t, DecisionUnprocessed, report.MergeRequests[1].Decision)
+	assert.Equal(t, DecisionUnprocessed, report.MergeRequests[2].Decision)
+	assert.Equal(t, 2, report.Totals.Unprocessed)
+	assert.Equal(t, int64(2), report.Totals.APICalls)
+}
+
+func TestAPILimiter_observe(t *testing.T) {
+	limiter := NewAPILimiter(10, 0)
+	reset := strconv.FormatInt(time.Now().Add(100*time.Second).Unix(), 10)
+
+	limiter.observe(&http.Response{Header: http.Header{
+		"Ratelimit-Remaining": []string{"50"},
+		"Ratelimit-Limit":     []string{"100"},
+		"Ratelimit-Reset":     []string{reset},
+	}})
+	assert.Equal(t, rate.Limit(10), limiter.limiter.Limit())
+
+	limiter.observe(&http.Response{Header: http.Header{
+		"Ratelimit-Remaining": []string{"10"},
+		"Ratelimit-Limit":     []string{"100"},
+		"Ratelimit-Reset":     []string{reset},
+	}})
+	assert.InDelta(t, 0.1, float64(limiter.limiter.Limit()), 0.01)
+
+	limiter.observe(&http.Response{Header: http.Header{
+		"Ratelimit-Remaining": []string{"90"},
+		"Ratelimit-Limit":     []string{"100"},
+	}})
+	assert.Equal(t, rate.Limit(10), limiter.limiter.Limit())
+}
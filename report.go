@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Decision is the outcome of a run on a merge request.
+type Decision string
+
+const (
+	DecisionMerged  Decision = "merged"
+	DecisionSkipped Decision = "skipped"
+	DecisionFailed  Decision = "failed"
+	DecisionPending Decision = "pending"
+	// DecisionDeferred is given to eligible merge requests not merged because a merge limit was reached.
+	DecisionDeferred Decision = "deferred"
+	// DecisionUnprocessed is given to merge requests not processed because run stopped early.
+	DecisionUnprocessed Decision = "unprocessed"
+	// DecisionQuarantined is given to merge requests not retried because they failed too many times in a row.
+	DecisionQuarantined Decision = "quarantined"
+)
+
+// Report formats supported by --report-format.
+const (
+	ReportFormatJSON = "json"
+)
+
+// MergeRequestResult is what happened to a merge request during a run.
+type MergeRequestResult struct {
+	IID          int64    `json:"iid"`
+	Title        string   `json:"title"`
+	URL          string   `json:"url"`
+	SHA          string   `json:"sha"`
+	SourceBranch string   `json:"source_branch"`
+	TargetBranch string   `json:"target_branch"`
+	Decision     Decision `json:"decision"`
+	Rule         string   `json:"rule,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	// MergeCommitSHA is the commit created by the merge, when merge request was merged.
+	MergeCommitSHA string `json:"merge_commit_sha,omitempty"`
+	// RevertIID is the merge request reverting the merge, when pipeline of merge commit failed.
+	RevertIID int64 `json:"revert_iid,omitempty"`
+	// NextRetryAt is when a quarantined merge request will be retried.
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	DurationMs  int64      `json:"duration_ms"`
+	Retries     int64      `json:"retries"`
+	Errors      []string   `json:"errors,omitempty"`
+}
+
+func newMergeRequestResult(mr *gitlab.BasicMergeRequest) *MergeRequestResult {
+	return &MergeRequestResult{
+		IID:          mr.IID,
+		Title:        mr.Title,
+		URL:          mr.WebURL,
+		SHA:          mr.SHA,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		StartedAt:    time.Now(),
+	}
+}
+
+// decide sets the decision taken on merge request, rule is a short identifier of the reason
+// (e.g. the failed eligibility check) used to group results.
+func (r *MergeRequestResult) decide(decision Decision, rule, reason string) {
+	r.Decision = decision
+	r.Rule = rule
+	r.Reason = reason
+}
+
+func (r *MergeRequestResult) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *MergeRequestResult) finish() {
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+}
+
+// ReportTotals counts merge requests by decision.
+type ReportTotals struct {
+	Considered  int   `json:"considered"`
+	Merged      int   `json:"merged"`
+	Skipped     int   `json:"skipped"`
+	Failed      int   `json:"failed"`
+	Pending     int   `json:"pending"`
+	Deferred    int   `json:"deferred"`
+	Unprocessed int   `json:"unprocessed"`
+	Quarantined int   `json:"quarantined"`
+	Retries     int64 `json:"retries"`
+	APICalls    int64 `json:"api_calls"`
+}
+
+// RunReport is the machine-readable result of a run.
+type RunReport struct {
+	RunID         string                `json:"run_id"`
+	Project       string                `json:"project"`
+	StartedAt     time.Time             `json:"started_at"`
+	FinishedAt    time.Time             `json:"finished_at"`
+	DurationMs    int64                 `json:"duration_ms"`
+	Totals        ReportTotals          `json:"totals"`
+	MergeRequests []*MergeRequestResult `json:"merge_requests"`
+	Errors        []string              `json:"errors,omitempty"`
+}
+
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func newRunReport(project string) *RunReport {
+	return &RunReport{
+		RunID:         newRunID(),
+		Project:       project,
+		StartedAt:     time.Now(),
+		MergeRequests: make([]*MergeRequestResult, 0),
+	}
+}
+
+func (r *RunReport) add(result *MergeRequestResult) {
+	result.finish()
+	r.MergeRequests = append(r.MergeRequests, result)
+}
+
+func (r *RunReport) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *RunReport) finish() {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	r.Totals = ReportTotals{
+		Considered: len(r.MergeRequests),
+		Retries:    r.Totals.Retries,
+		APICalls:   r.Totals.APICalls,
+	}
+	for _, result := range r.MergeRequests {
+		switch result.Decision {
+		case DecisionMerged:
+			r.Totals.Merged++
+		case DecisionSkipped:
+			r.Totals.Skipped++
+		case DecisionFailed:
+			r.Totals.Failed++
+		case DecisionPending:
+			r.Totals.Pending++
+		case DecisionDeferred:
+			r.Totals.Deferred++
+		case DecisionUnprocessed:
+			r.Totals.Unprocessed++
+		case DecisionQuarantined:
+			r.Totals.Quarantined++
+		}
+	}
+}
+
+func checkReportFormat(format string) error {
+	if format != ReportFormatJSON {
+		return fmt.Errorf("unknown report format '%s', must be one of: %s", format, ReportFormatJSON)
+	}
+	return nil
+}
+
+// write writes report to file in given format.
+func (r *RunReport) write(path, format string) error {
+	if err := checkReportFormat(format); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_RunReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[
+				{"iid": 1, "title": "Bump foo", "sha": "abc", "web_url": "https://gitlab/mr/1"},
+				{"iid": 2, "title": "Draft: Bump bar", "draft": true}
+			]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	acceptMr := &AcceptMr{
+		Client:       client,
+		ProjectName:  "test-project",
+		ReportFile:   reportFile,
+		ReportFormat: ReportFormatJSON,
+		JUnitFile:    filepath.Join(t.TempDir(), "junit.xml"),
+	}
+	err = acceptMr.Run()
+	assert.NoError(t, err)
+
+	b, err := os.ReadFile(reportFile)
+	assert.NoError(t, err)
+	report := RunReport{}
+	err = json.Unmarshal(b, &report)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, report.RunID)
+	assert.Equal(t, ReportTotals{Considered: 2, Merged: 1, Skipped: 1}, report.Totals)
+	assert.Equal(t, DecisionMerged, report.MergeRequests[0].Decision)
+	assert.Equal(t, "https://gitlab/mr/1", report.MergeRequests[0].URL)
+	assert.Equal(t, DecisionSkipped, report.MergeRequests[1].Decision)
+	assert.Equal(t, "draft check failed: merge request is in draft", report.MergeRequests[1].Reason)
+}
+
+func TestRunReport_writeJUnit(t *testing.T) {
+	report := newRunReport("test-project")
+	report.add(&MergeRequestResult{IID: 1, Title: "Bump foo", Decision: DecisionMerged})
+	report.add(&MergeRequestResult{IID: 2, Title: "Bump bar", Decision: DecisionSkipped, Reason: "draft check failed: merge request is in draft"})
+	report.add(&MergeRequestResult{IID: 3, Title: "Bump baz", Decision: DecisionFailed, Reason: "conflict", Errors: []string{"error occurred while accepting"}})
+	report.finish()
+
+	junitFile := filepath.Join(t.TempDir(), "junit.xml")
+	err := report.writeJUnit(junitFile)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(junitFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `<testsuite name="test-project" tests="3" skipped="1" failures="1"`)
+	assert.Contains(t, string(b), `<skipped message="draft check failed: merge request is in draft"></skipped>`)
+	assert.Contains(t, string(b), `<failure message="conflict" type="MergeError">error occurred while accepting</failure>`)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// RetryPolicy defines how requests to gitlab api are retried on transient errors.
+// Requests are retried on connection errors and 429 responses, 5xx responses and transport errors once
+// connected are only retried for idempotent requests, and 5xx responses for the merge call too.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction of the delay randomly added or removed (e.g. 0.2 for +/-20%).
+	Jitter float64
+
+	retries atomic.Int64
+}
+
+// ClientOptions returns gitlab client options applying the policy.
+func (p *RetryPolicy) ClientOptions() []gitlab.ClientOptionFunc {
+	if p.MaxAttempts <= 1 {
+		return []gitlab.ClientOptionFunc{gitlab.WithoutRetries()}
+	}
+	return []gitlab.ClientOptionFunc{
+		gitlab.WithCustomRetryMax(p.MaxAttempts - 1),
+		gitlab.WithCustomRetryWaitMinMax(p.BaseDelay, p.MaxDelay),
+		gitlab.WithCustomRetry(p.checkRetry),
+		gitlab.WithCustomBackoff(p.backoff),
+	}
+}
+
+// Retries returns the number of retries done since policy creation.
+func (p *RetryPolicy) Retries() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.retries.Load()
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isMergeCall(req *http.Request) bool {
+	return req.Method == http.MethodPut && strings.HasSuffix(req.URL.Path, "/merge")
+}
+
+func (p *RetryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		// budget stays exhausted for the run, retrying would only wait
+		if errors.Is(err, ErrAPIBudgetExhausted) {
+			return false, err
+		}
+		var dnsErr *net.DNSError
+		var opErr *net.OpError
+		if errors.As(err, &dnsErr) {
+			return !dnsErr.IsNotFound, nil
+		}
+		// connection was never established, request can be safely sent again whatever its method
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true, nil
+		}
+		// connection reset or timeout, only requests without side effect can be sent again
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) && isIdempotent(strings.ToUpper(urlErr.Op)), nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true, nil
+	}
+	if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+		return isIdempotent(resp.Request.Method) || isMergeCall(resp.Request), nil
+	}
+	return false, nil
+}
+
+// backoff waits exponentially from min to max with jitter,
+// unless gitlab tells when to retry with Retry-After or RateLimit-Reset headers.
+func (p *RetryPolicy) backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	wait := time.Duration(float64(min) * math.Pow(2, float64(attemptNum)))
+	if wait > max || wait <= 0 {
+		wait = max
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	// a wrong header must not stall the run, waiting is bounded by max delay
+	if headerWait, ok := retryAfter(resp); ok && headerWait > wait {
+		wait = headerWait
+		if wait > max {
+			wait = max
+		}
+	}
+	p.retries.Add(1)
+
+	entry := log.WithField("attempt", attemptNum+2)
+	if resp != nil && resp.Request != nil {
+		entry = entry.WithFields(log.Fields{
+			"method": resp.Request.Method,
+			"path":   resp.Request.URL.Path,
+			"status": resp.StatusCode,
+		})
+	}
+	entry.Warnf("Retrying gitlab api request in %s", wait.Round(time.Millisecond))
+	return wait
+}
+
+// retryAfter reads time to wait from Retry-After or RateLimit-Reset response headers.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(v); err == nil {
+			return time.Until(date), true
+		}
+	}
+	if v := resp.Header.Get("RateLimit-Reset"); v != "" {
+		if reset, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+	}
+	return 0, false
+}
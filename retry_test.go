@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	calls := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.Method+" "+r.URL.Path]++
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			if calls[r.Method+" "+r.URL.Path] == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			if calls[r.Method+" "+r.URL.Path] == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Jitter: 0.2}
+	client, err := gitlab.NewClient("", append([]gitlab.ClientOptionFunc{gitlab.WithBaseURL(ts.URL)}, policy.ClientOptions()...)...)
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", Message: "Merged", RetryPolicy: policy}
+	err = acceptMr.Run()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, calls["GET /api/v4/projects/test-project/merge_requests"])
+	assert.Equal(t, 2, calls["PUT /api/v4/projects/test-project/merge_requests/1/merge"])
+	// creating a note is not idempotent, it must not be retried on server errors
+	assert.Equal(t, 1, calls["POST /api/v4/projects/test-project/merge_requests/1/notes"])
+	assert.Equal(t, int64(2), acceptMr.LastReport.Totals.Retries)
+	assert.Equal(t, int64(1), acceptMr.LastReport.MergeRequests[0].Retries)
+	assert.Equal(t, DecisionMerged, acceptMr.LastReport.MergeRequests[0].Decision)
+}
+
+func TestRetryAfter(t *testing.T) {
+	wait, ok := retryAfter(&http.Response{Header: http.Header{"Retry-After": []string{"3"}}})
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, wait)
+
+	reset := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	wait, ok = retryAfter(&http.Response{Header: http.Header{"Ratelimit-Reset": []string{reset}}})
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, wait, float64(2*time.Second))
+
+	_, ok = retryAfter(&http.Response{Header: http.Header{}})
+	assert.False(t, ok)
+}
+
+func TestRetryPolicy_checkRetry(t *testing.T) {
+	policy := &RetryPolicy{}
+	reset := &url.Error{Op: "Get", URL: "https://gitlab/api/v4/projects", Err: syscall.ECONNRESET}
+	retry, err := policy.checkRetry(context.Background(), nil, reset)
+	assert.NoError(t, err)
+	assert.True(t, retry)
+
+	reset.Op = "Post"
+	retry, _ = policy.checkRetry(context.Background(), nil, reset)
+	assert.False(t, retry)
+
+	dial := &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	retry, _ = policy.checkRetry(context.Background(), nil, dial)
+	assert.True(t, retry)
+
+	budget := &url.Error{Op: "Get", URL: "https://gitlab/api/v4/projects", Err: ErrAPIBudgetExhausted}
+	retry, err = policy.checkRetry(context.Background(), nil, budget)
+	assert.ErrorIs(t, err, ErrAPIBudgetExhausted)
+	assert.False(t, retry)
+}
+
+func TestRetryPolicy_backoffCapsHeader(t *testing.T) {
+	policy := &RetryPolicy{}
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3600"}}}
+	assert.Equal(t, 10*time.Second, policy.backoff(time.Second, 10*time.Second, 0, resp))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// RevertOptions selects merges undone by revert command, a merge matching any of the criteria is reverted.
+type RevertOptions struct {
+	IIDs  []int64
+	RunID string
+	// Since and Until bound merge time, zero values leave the range open.
+	Since  time.Time
+	Until  time.Time
+	Reason string
+	DryRun bool
+}
+
+func (o RevertOptions) byTime() bool {
+	return !o.Since.IsZero() || !o.Until.IsZero()
+}
+
+func (o RevertOptions) validate() error {
+	if len(o.IIDs) == 0 && o.RunID == "" && !o.byTime() {
+		return fmt.Errorf("a merge request, a run id or a time range must be given")
+	}
+	if !o.Since.IsZero() && !o.Until.IsZero() && !o.Since.Before(o.Until) {
+		return fmt.Errorf("since must be before until")
+	}
+	return nil
+}
+
+// matches tells if a merge recorded in state is selected.
+func (o RevertOptions) matches(state *MergeRequestState) bool {
+	if state.MergedAt == nil || state.MergeCommitSHA == "" {
+		return false
+	}
+	if slices.Contains(o.IIDs, state.IID) {
+		return true
+	}
+	if o.RunID != "" && state.MergeRunID == o.RunID {
+		return true
+	}
+	if !o.byTime() {
+		return false
+	}
+	return (o.Since.IsZero() || !state.MergedAt.Before(o.Since)) && (o.Until.IsZero() || state.MergedAt.Before(o.Until))
+}
+
+// mergesToRevert returns merges selected by opt, most recent first so that reverts apply on top of each other.
+// Merge requests given by iid which are unknown in state are read from gitlab.
+func (a *AcceptMr) mergesToRevert(ctx context.Context, opt RevertOptions) ([]*MergeRequestState, error) {
+	if a.State == nil && (opt.RunID != "" || opt.byTime()) {
+		return nil, fmt.Errorf("state is required to revert merges of a run or a time range")
+	}
+	var states []*MergeRequestState
+	if a.State != nil {
+		var err error
+		states, err = a.State.List(a.ProjectName)
+		if err != nil {
+			return nil, fmt.Errorf("error when reading state: %s", err.Error())
+		}
+	}
+	var merges []*MergeRequestState
+	for _, state := range states {
+		if opt.matches(state) {
+			merges = append(merges, state)
+		}
+	}
+	for _, iid := range opt.IIDs {
+		if slices.ContainsFunc(merges, func(state *MergeRequestState) bool { return state.IID == iid }) {
+			continue
+		}
+		mr, _, err := a.Client.MergeRequests.GetMergeRequest(a.ProjectName, iid, nil, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("error when reading merge request !%d: %s", iid, err.Error())
+		}
+		if mr.State != "merged" || mergedCommitSHA(mr) == "" {
+			return nil, fmt.Errorf("merge request !%d has no merge commit to revert", iid)
+		}
+		merges = append(merges, &MergeRequestState{
+			IID:            mr.IID,
+			Title:          mr.Title,
+			SHA:            mr.SHA,
+			TargetBranch:   mr.TargetBranch,
+			MergedAt:       mr.MergedAt,
+			MergeCommitSHA: mergedCommitSHA(mr),
+		})
+	}
+	sort.SliceStable(merges, func(i, j int) bool {
+		if merges[i].MergedAt == nil || merges[j].MergedAt == nil {
+			return merges[j].MergedAt == nil && merges[i].MergedAt != nil
+		}
+		return merges[i].MergedAt.After(*merges[j].MergedAt)
+	})
+	return merges, nil
+}
+
+// revertMerge reverts merge commit on target branch, or with a merge request when target branch is protected.
+// It returns the revert commit or merge request.
+func (a *AcceptMr) revertMerge(ctx context.Context, merge *MergeRequestState, reason string) (string, error) {
+	branch, _, err := a.Client.Branches.GetBranch(a.ProjectName, merge.TargetBranch, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error when reading branch %s: %s", merge.TargetBranch, err.Error())
+	}
+	if branch.Protected {
+		revert, err := a.openRevertMergeRequest(ctx, merge, reason)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("!%d", revert.IID), nil
+	}
+	commit, _, err := a.Client.Commits.RevertCommit(a.ProjectName, merge.MergeCommitSHA, &gitlab.RevertCommitOptions{
+		Branch: &merge.TargetBranch,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error when reverting commit %s: %s", merge.MergeCommitSHA, err.Error())
+	}
+	return commit.ID, nil
+}
+
+// Revert undoes merges made by accept-mr: merges of given merge requests, merges of a run or merges within a
+// time range. Reverted merge requests are annotated with a note and reverts are recorded in state.
+func (a *AcceptMr) Revert(ctx context.Context, opt RevertOptions) error {
+	if err := opt.validate(); err != nil {
+		return err
+	}
+	merges, err := a.mergesToRevert(ctx, opt)
+	if err != nil {
+		return err
+	}
+	if len(merges) == 0 {
+		log.Info("No merge to revert")
+		return nil
+	}
+	reason := opt.Reason
+	if reason == "" {
+		reason = "reverted with accept-mr revert"
+	}
+	nbErrors := 0
+	for _, merge := range merges {
+		entry := log.WithFields(log.Fields{"project": a.ProjectName, "mr_iid": merge.IID, "target_branch": merge.TargetBranch})
+		if merge.RevertedAt != nil {
+			entry.Infof("Merge already reverted by %s, skipping", merge.RevertedBy)
+			continue
+		}
+		if opt.DryRun {
+			entry.Infof("Would revert merge commit %s", merge.MergeCommitSHA)
+			continue
+		}
+		revertedBy, err := a.revertMerge(ctx, merge, reason)
+		if err != nil {
+			nbErrors++
+			entry.Errorf("could not revert merge: %s", err.Error())
+			continue
+		}
+		entry.Infof("Merge commit %s reverted by %s", merge.MergeCommitSHA, revertedBy)
+		now := time.Now()
+		merge.RevertedAt = &now
+		merge.RevertedBy = revertedBy
+		if a.State != nil {
+			if err := a.State.Put(a.ProjectName, merge); err != nil {
+				entry.Warnf("could not record revert in state: %s", err.Error())
+			}
+		}
+		body := fmt.Sprintf("Merge commit %s has been reverted by %s: %s.", merge.MergeCommitSHA, revertedBy, reason)
+		_, _, err = a.Client.Notes.CreateMergeRequestNote(a.ProjectName, merge.IID, &gitlab.CreateMergeRequestNoteOptions{
+			Body: &body,
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			entry.Warnf("could not comment on merge request: %s", err.Error())
+		}
+	}
+	if a.State != nil {
+		if err := a.State.Flush(); err != nil {
+			log.Errorf("could not save state: %s", err.Error())
+		}
+	}
+	if nbErrors > 0 {
+		return fmt.Errorf("%d merge(s) could not be reverted", nbErrors)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestRevertOptions_matches(t *testing.T) {
+	mergedAt := date("2024-05-01T10:00:00Z")
+	state := &MergeRequestState{IID: 1, MergedAt: &mergedAt, MergeRunID: "run-1", MergeCommitSHA: "abc"}
+	assert.True(t, RevertOptions{IIDs: []int64{1}}.matches(state))
+	assert.True(t, RevertOptions{RunID: "run-1"}.matches(state))
+	assert.False(t, RevertOptions{RunID: "run-2"}.matches(state))
+	assert.True(t, RevertOptions{Since: mergedAt}.matches(state))
+	assert.False(t, RevertOptions{Until: mergedAt}.matches(state))
+	assert.True(t, RevertOptions{Since: date("2024-05-01T00:00:00Z"), Until: date("2024-05-02T00:00:00Z")}.matches(state))
+	assert.False(t, RevertOptions{IIDs: []int64{1}}.matches(&MergeRequestState{IID: 1}))
+
+	assert.Error(t, RevertOptions{}.validate())
+	assert.Error(t, RevertOptions{Since: mergedAt, Until: mergedAt}.validate())
+}
+
+func TestAcceptMr_Revert(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/repository/branches/main":
+			_, _ = w.Write([]byte(`{"name": "main"}`))
+		case "/api/v4/projects/test-project/repository/branches/release":
+			_, _ = w.Write([]byte(`{"name": "release", "protected": true}`))
+		case "/api/v4/projects/test-project/repository/branches":
+			calls = append(calls, "create branch")
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/repository/commits/sha1/revert",
+			"/api/v4/projects/test-project/repository/commits/sha2/revert",
+			"/api/v4/projects/test-project/repository/commits/sha5/revert":
+			opt := map[string]string{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&opt))
+			calls = append(calls, "revert "+filepath.Base(filepath.Dir(r.URL.Path))+" on "+opt["branch"])
+			_, _ = w.Write([]byte(`{"id": "revert-` + filepath.Base(filepath.Dir(r.URL.Path)) + `"}`))
+		case "/api/v4/projects/test-project/merge_requests/5":
+			_, _ = w.Write([]byte(`{"iid": 5, "title": "Release", "state": "merged", "target_branch": "release", "merge_commit_sha": "sha5"}`))
+		case "/api/v4/projects/test-project/merge_requests":
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			calls = append(calls, "create revert merge request")
+			_, _ = w.Write([]byte(`{"iid": 6}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes",
+			"/api/v4/projects/test-project/merge_requests/2/notes",
+			"/api/v4/projects/test-project/merge_requests/5/notes":
+			note := map[string]string{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&note))
+			calls = append(calls, note["body"])
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	store, err := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+	first, second, third := date("2024-05-01T10:00:00Z"), date("2024-05-01T10:05:00Z"), date("2024-05-02T10:00:00Z")
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 1, TargetBranch: "main", MergedAt: &first, MergeRunID: "run-1", MergeCommitSHA: "sha1"}))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 2, TargetBranch: "main", MergedAt: &second, MergeRunID: "run-1", MergeCommitSHA: "sha2"}))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 3, TargetBranch: "main", MergedAt: &third, MergeRunID: "run-2", MergeCommitSHA: "sha3"}))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 4, TargetBranch: "main"}))
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", State: store}
+	err = acceptMr.Revert(context.Background(), RevertOptions{RunID: "run-1", Reason: "broken deployment"})
+	assert.NoError(t, err)
+	// most recent merge is reverted first
+	assert.Equal(t, []string{
+		"revert sha2 on main",
+		"Merge commit sha2 has been reverted by revert-sha2: broken deployment.",
+		"revert sha1 on main",
+		"Merge commit sha1 has been reverted by revert-sha1: broken deployment.",
+	}, calls)
+	state, err := store.Get("test-project", 2)
+	assert.NoError(t, err)
+	assert.NotNil(t, state.RevertedAt)
+	assert.Equal(t, "revert-sha2", state.RevertedBy)
+
+	// merges are reverted only once
+	calls = nil
+	err = acceptMr.Revert(context.Background(), RevertOptions{Since: first, Until: third})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	// merge request unknown in state, on a protected branch
+	err = acceptMr.Revert(context.Background(), RevertOptions{IIDs: []int64{5}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"create branch",
+		"revert sha5 on accept-mr/revert/5-sha5",
+		"create revert merge request",
+		"Merge commit sha5 has been reverted by !6: reverted with accept-mr revert.",
+	}, calls)
+}
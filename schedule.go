@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// TimeWindow is a recurring period of time.
+type TimeWindow interface {
+	Contains(t time.Time) bool
+	String() string
+}
+
+// Schedule tells when merges are allowed: only inside MergeWindows when some are set,
+// never inside FreezeWindows nor, when FreezePeriods is set, inside freeze periods of the gitlab project.
+// All methods are safe to call on a nil Schedule.
+type Schedule struct {
+	MergeWindows  []TimeWindow
+	FreezeWindows []TimeWindow
+	FreezePeriods bool
+
+	projectPeriods []TimeWindow
+}
+
+// NewSchedule creates a schedule from window specs, see parseTimeWindow for their syntax.
+func NewSchedule(mergeWindows, freezeWindows []string, freezePeriods bool) (*Schedule, error) {
+	s := &Schedule{FreezePeriods: freezePeriods}
+	for _, spec := range mergeWindows {
+		w, err := parseTimeWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid merge window: %s", err.Error())
+		}
+		s.MergeWindows = append(s.MergeWindows, w)
+	}
+	for _, spec := range freezeWindows {
+		w, err := parseTimeWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid freeze window: %s", err.Error())
+		}
+		s.FreezeWindows = append(s.FreezeWindows, w)
+	}
+	return s, nil
+}
+
+func (s *Schedule) enabled() bool {
+	return s != nil && (len(s.MergeWindows) > 0 || len(s.FreezeWindows) > 0 || s.FreezePeriods)
+}
+
+// loadFreezePeriods reads freeze periods of project, they are read again at each run.
+func (s *Schedule) loadFreezePeriods(ctx context.Context, client *gitlab.Client, project string) error {
+	if s == nil || !s.FreezePeriods {
+		return nil
+	}
+	periods, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.FreezePeriod, *gitlab.Response, error) {
+		return client.FreezePeriods.ListFreezePeriods(project, &gitlab.ListFreezePeriodsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return fmt.Errorf("error when reading freeze periods: %s", err.Error())
+	}
+	windows := make([]TimeWindow, 0, len(periods))
+	for _, period := range periods {
+		w, err := newCronWindow(period.FreezeStart, period.FreezeEnd, period.CronTimezone)
+		if err != nil {
+			return fmt.Errorf("invalid freeze period %d: %s", period.ID, err.Error())
+		}
+		windows = append(windows, w)
+	}
+	s.projectPeriods = windows
+	return nil
+}
+
+// frozen tells if merges are forbidden at t and why.
+func (s *Schedule) frozen(t time.Time) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	for _, w := range s.projectPeriods {
+		if w.Contains(t) {
+			return fmt.Sprintf("frozen by project freeze period '%s'", w), true
+		}
+	}
+	for _, w := range s.FreezeWindows {
+		if w.Contains(t) {
+			return fmt.Sprintf("frozen by freeze window '%s'", w), true
+		}
+	}
+	if len(s.MergeWindows) == 0 {
+		return "", false
+	}
+	specs := make([]string, len(s.MergeWindows))
+	for i, w := range s.MergeWindows {
+		if w.Contains(t) {
+			return "", false
+		}
+		specs[i] = w.String()
+	}
+	return fmt.Sprintf("frozen outside merge windows '%s'", strings.Join(specs, "', '")), true
+}
+
+func (a *AcceptMr) checkSchedule(mr *gitlab.BasicMergeRequest) Check {
+	if reason, frozen := a.Schedule.frozen(time.Now()); frozen {
+		return failed("Schedule", reason)
+	}
+	return passed("Schedule", "merges are allowed now")
+}
+
+// parseTimeWindow parses a time window given either as a weekly range or as a pair of cron expressions:
+//
+//	[days] HH:MM-HH:MM [time zone]      e.g. "Mon-Fri 09:00-18:00 Europe/Paris", "22:00-06:00"
+//	cron:<start>;<end>[;<time zone>]    e.g. "cron:0 18 * * 5;0 8 * * 1;Europe/Paris"
+//
+// Time zone defaults to local time zone.
+func parseTimeWindow(spec string) (TimeWindow, error) {
+	if cron, ok := strings.CutPrefix(spec, "cron:"); ok {
+		parts := strings.Split(cron, ";")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("'%s' must be of the form cron:<start>;<end>[;<time zone>]", spec)
+		}
+		tz := ""
+		if len(parts) == 3 {
+			tz = parts[2]
+		}
+		return newCronWindow(parts[0], parts[1], tz)
+	}
+	return parseWeeklyWindow(spec)
+}
+
+func loadLocation(tz string) (*time.Location, error) {
+	tz = strings.TrimSpace(tz)
+	if tz == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// weeklyWindow is a range of hours on some days of the week, a range ending before it starts
+// spans midnight and belongs to the day it starts.
+type weeklyWindow struct {
+	spec  string
+	days  [7]bool
+	start int
+	end   int
+	loc   *time.Location
+}
+
+func parseWeeklyWindow(spec string) (*weeklyWindow, error) {
+	fields := strings.Fields(spec)
+	w := &weeklyWindow{spec: strings.Join(fields, " "), loc: time.Local}
+	hoursIdx := -1
+	for i, field := range fields {
+		if strings.Contains(field, ":") {
+			hoursIdx = i
+			break
+		}
+	}
+	if hoursIdx < 0 || hoursIdx > 1 || len(fields) > hoursIdx+2 {
+		return nil, fmt.Errorf("'%s' must be of the form [days] HH:MM-HH:MM [time zone]", spec)
+	}
+	if hoursIdx == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	} else {
+		err := w.parseDays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", spec, err.Error())
+		}
+	}
+	start, end, ok := strings.Cut(fields[hoursIdx], "-")
+	if !ok {
+		return nil, fmt.Errorf("'%s': hours must be a range HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return nil, fmt.Errorf("'%s': %s", spec, err.Error())
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return nil, fmt.Errorf("'%s': %s", spec, err.Error())
+	}
+	if len(fields) > hoursIdx+1 {
+		if w.loc, err = loadLocation(fields[hoursIdx+1]); err != nil {
+			return nil, fmt.Errorf("'%s': %s", spec, err.Error())
+		}
+	}
+	return w, nil
+}
+
+func parseWeekday(name string) (int, error) {
+	for i, day := range weekdayNames {
+		if strings.EqualFold(name, day) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day '%s'", name)
+}
+
+func (w *weeklyWindow) parseDays(days string) error {
+	for _, item := range strings.Split(days, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		first, err := parseWeekday(from)
+		if err != nil {
+			return err
+		}
+		last := first
+		if isRange {
+			if last, err = parseWeekday(to); err != nil {
+				return err
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into minutes since midnight, 24:00 is accepted as end of day.
+func parseClock(clock string) (int, error) {
+	h, m, ok := strings.Cut(clock, ":")
+	hours, errH := strconv.Atoi(h)
+	minutes, errM := strconv.Atoi(m)
+	if !ok || errH != nil || errM != nil || hours < 0 || minutes < 0 || minutes > 59 ||
+		hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("invalid time '%s'", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+func (w *weeklyWindow) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	day := int(t.Weekday())
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	return (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end)
+}
+
+func (w *weeklyWindow) String() string {
+	return w.spec
+}
+
+// cronWindow is the period between a time matching start cron expression and the next time
+// matching end cron expression, like gitlab freeze periods.
+type cronWindow struct {
+	startSpec string
+	endSpec   string
+	start     *cronExpr
+	end       *cronExpr
+	loc       *time.Location
+}
+
+func newCronWindow(start, end, tz string) (*cronWindow, error) {
+	w := &cronWindow{startSpec: strings.TrimSpace(start), endSpec: strings.TrimSpace(end)}
+	var err error
+	if w.start, err = parseCron(w.startSpec); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseCron(w.endSpec); err != nil {
+		return nil, err
+	}
+	if w.loc, err = loadLocation(tz); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Contains tells if start expression matched more recently than end expression.
+func (w *cronWindow) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	start, ok := w.start.prev(t)
+	if !ok {
+		return false
+	}
+	end, ok := w.end.prev(t)
+	return !ok || start.After(end)
+}
+
+func (w *cronWindow) String() string {
+	s := w.startSpec + " to " + w.endSpec
+	if w.loc != time.Local {
+		s += " " + w.loc.String()
+	}
+	return s
+}
+
+// cronExpr is a standard 5 fields cron expression: minute, hour, day of month, month and day of week.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+func parseCron(spec string) (*cronExpr, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields", spec)
+	}
+	c := &cronExpr{
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron expression '%s': %s", spec, err.Error())
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron expression '%s': %s", spec, err.Error())
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron expression '%s': %s", spec, err.Error())
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron expression '%s': %s", spec, err.Error())
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, fmt.Errorf("cron expression '%s': %s", spec, err.Error())
+	}
+	// 7 is sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a cron field made of values, ranges and steps separated by commas into a bit set.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepStr)
+			}
+		}
+		first, last := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = parseCronValue(from, min, max, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(to, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = max
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range '%s'", rng)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value '%s'", value)
+	}
+	return v, nil
+}
+
+func (c *cronExpr) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	// as in cron, when both day of month and day of week are restricted, either of them matches
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// prev returns the last time at or before t matching expression, looking back up to 5 years.
+func (c *cronExpr) prev(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	limit := t.AddDate(-5, 0, 0)
+	for t.After(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(-time.Minute)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseTimeWindow_weekly(t *testing.T) {
+	w, err := parseTimeWindow("Mon-Fri 09:00-18:00 Europe/Paris")
+	assert.NoError(t, err)
+	// 2024-06-03 is a monday, Paris is UTC+2 in summer
+	assert.True(t, w.Contains(date("2024-06-03T07:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-03T06:59:00Z")))
+	assert.False(t, w.Contains(date("2024-06-03T16:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-08T10:00:00Z")))
+
+	w, err = parseTimeWindow("Fri,Sat 22:00-06:00 UTC")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(date("2024-06-07T23:00:00Z")))
+	assert.True(t, w.Contains(date("2024-06-09T05:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-10T05:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-07T05:00:00Z")))
+
+	w, err = parseTimeWindow("Sat-Sun 00:00-24:00 UTC")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(date("2024-06-09T23:59:00Z")))
+	assert.False(t, w.Contains(date("2024-06-10T00:00:00Z")))
+
+	for _, spec := range []string{"", "Mon-Fri", "Mon-Fri 9h-18h", "Foo 09:00-18:00", "09:00-25:00", "09:00-18:00 Nowhere/City", "Mon 09:00-18:00 UTC extra"} {
+		_, err := parseTimeWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseTimeWindow_cron(t *testing.T) {
+	// frozen from friday 18:00 to monday 08:00
+	w, err := parseTimeWindow("cron:0 18 * * fri;0 8 * * mon;UTC")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(date("2024-06-07T18:00:00Z")))
+	assert.True(t, w.Contains(date("2024-06-09T12:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-10T08:00:00Z")))
+	assert.False(t, w.Contains(date("2024-06-12T12:00:00Z")))
+	assert.Equal(t, "0 18 * * fri to 0 8 * * mon UTC", w.String())
+
+	// frozen from december 20th to january 3rd
+	w, err = parseTimeWindow("cron:0 0 20 12 *;0 0 3 1 *;UTC")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(date("2024-12-31T12:00:00Z")))
+	assert.True(t, w.Contains(date("2025-01-02T23:59:00Z")))
+	assert.False(t, w.Contains(date("2025-01-03T00:00:00Z")))
+	assert.False(t, w.Contains(date("2024-12-19T23:59:00Z")))
+
+	for _, spec := range []string{"cron:0 18 * * 5", "cron:0 18 * *;0 8 * * 1", "cron:60 18 * * 5;0 8 * * 1", "cron:0 18 * * 5;0 8 * * 1;Nowhere/City"} {
+		_, err := parseTimeWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCronExpr_prev(t *testing.T) {
+	c, err := parseCron("*/15 9-17 1,15 * 7")
+	assert.NoError(t, err)
+	// day of month and day of week both restricted: either matches, 2024-06-09 is a sunday
+	prev, ok := c.prev(date("2024-06-09T12:07:30Z"))
+	assert.True(t, ok)
+	assert.Equal(t, date("2024-06-09T12:00:00Z"), prev)
+	prev, ok = c.prev(date("2024-06-09T08:00:00Z"))
+	assert.True(t, ok)
+	assert.Equal(t, date("2024-06-02T17:45:00Z"), prev)
+
+	c, err = parseCron("0 0 29 2 *")
+	assert.NoError(t, err)
+	prev, ok = c.prev(date("2025-06-01T00:00:00Z"))
+	assert.True(t, ok)
+	assert.Equal(t, date("2024-02-29T00:00:00Z"), prev)
+}
+
+func TestSchedule_frozen(t *testing.T) {
+	s, err := NewSchedule([]string{"Mon-Fri 09:00-18:00 UTC"}, []string{"cron:0 0 20 12 *;0 0 3 1 *;UTC"}, false)
+	assert.NoError(t, err)
+
+	_, frozen := s.frozen(date("2024-06-03T10:00:00Z"))
+	assert.False(t, frozen)
+	reason, frozen := s.frozen(date("2024-06-03T20:00:00Z"))
+	assert.True(t, frozen)
+	assert.Equal(t, "frozen outside merge windows 'Mon-Fri 09:00-18:00 UTC'", reason)
+	reason, frozen = s.frozen(date("2024-12-23T10:00:00Z"))
+	assert.True(t, frozen)
+	assert.Equal(t, "frozen by freeze window '0 0 20 12 * to 0 0 3 1 * UTC'", reason)
+
+	_, err = NewSchedule([]string{"Mon-Fri"}, nil, false)
+	assert.EqualError(t, err, "invalid merge window: 'Mon-Fri' must be of the form [days] HH:MM-HH:MM [time zone]")
+	var nilSchedule *Schedule
+	_, frozen = nilSchedule.frozen(time.Now())
+	assert.False(t, frozen)
+}
+
+func TestAcceptMr_freezePeriods(t *testing.T) {
+	accepted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo", "detailed_merge_status": "mergeable"}]`))
+		case "/api/v4/projects/test-project/freeze_periods":
+			// a freeze period which never ends
+			_, _ = w.Write([]byte(`[{"id": 1, "freeze_start": "* * * * *", "freeze_end": "0 0 31 2 *", "cron_timezone": "UTC"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			accepted = true
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", Schedule: &Schedule{FreezePeriods: true}}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, accepted)
+	result := acceptMr.LastReport.MergeRequests[0]
+	assert.Equal(t, DecisionSkipped, result.Decision)
+	assert.Equal(t, "schedule", result.Rule)
+	assert.Equal(t, "schedule check failed: frozen by project freeze period '* * * * * to 0 0 31 2 * UTC'", result.Reason)
+}
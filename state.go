@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// State backends supported by --state-backend.
+const (
+	StateBackendFile = "file"
+	StateBackendNone = "none"
+)
+
+// maxStateHistory is the number of events kept in history of a merge request.
+const maxStateHistory = 50
+
+// StateEvent is a decision taken on a merge request during a run.
+type StateEvent struct {
+	RunID    string    `json:"run_id"`
+	At       time.Time `json:"at"`
+	SHA      string    `json:"sha"`
+	Decision Decision  `json:"decision"`
+	Rule     string    `json:"rule,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// MergeRequestState is what is known about a merge request from previous runs.
+type MergeRequestState struct {
+	IID             int64      `json:"iid"`
+	Title           string     `json:"title"`
+	SHA             string     `json:"sha"`
+	TargetBranch    string     `json:"target_branch"`
+	Labels          []string   `json:"labels,omitempty"`
+	FirstSeenAt     time.Time  `json:"first_seen_at"`
+	LastEvaluatedAt time.Time  `json:"last_evaluated_at"`
+	Evaluations     int        `json:"evaluations"`
+	Failures        int        `json:"failures"`
+	LastFailureAt   *time.Time `json:"last_failure_at,omitempty"`
+	// ConsecutiveFailures counts failures with the same reason since last merge or release from quarantine.
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	QuarantinedUntil    *time.Time `json:"quarantined_until,omitempty"`
+	MergedAt            *time.Time `json:"merged_at,omitempty"`
+	MergeRunID          string     `json:"merge_run_id,omitempty"`
+	MergeCommitSHA      string     `json:"merge_commit_sha,omitempty"`
+	// RevertedAt and RevertedBy tell when and by which commit or merge request the merge was reverted.
+	RevertedAt *time.Time `json:"reverted_at,omitempty"`
+	RevertedBy string     `json:"reverted_by,omitempty"`
+	// VerificationPending is set while pipeline of merge commit is not verified, see --verify-pipeline.
+	VerificationPending bool `json:"verification_pending,omitempty"`
+	// RevertIID is the merge request opened to revert the merge when pipeline of merge commit failed.
+	RevertIID int64        `json:"revert_iid,omitempty"`
+	History   []StateEvent `json:"history"`
+}
+
+// LastEvent returns the last decision taken on merge request, nil if it was never evaluated.
+func (s *MergeRequestState) LastEvent() *StateEvent {
+	if len(s.History) == 0 {
+		return nil
+	}
+	return &s.History[len(s.History)-1]
+}
+
+// lastFailure returns the last failure of merge request in history, nil if there is none.
+func (s *MergeRequestState) lastFailure() *StateEvent {
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if s.History[i].Decision == DecisionFailed {
+			return &s.History[i]
+		}
+	}
+	return nil
+}
+
+// record adds result of a run to merge request state.
+func (s *MergeRequestState) record(runID string, result *MergeRequestResult, labels []string) {
+	if s.FirstSeenAt.IsZero() {
+		s.FirstSeenAt = result.StartedAt
+	}
+	s.Title = result.Title
+	s.SHA = result.SHA
+	s.TargetBranch = result.TargetBranch
+	s.Labels = labels
+	s.LastEvaluatedAt = result.StartedAt
+	s.Evaluations++
+	at := result.StartedAt
+	switch result.Decision {
+	case DecisionFailed:
+		last := s.lastFailure()
+		if last != nil && last.Rule == result.Rule && last.Reason == result.Reason {
+			s.ConsecutiveFailures++
+		} else {
+			s.ConsecutiveFailures = 1
+		}
+		s.Failures++
+		s.LastFailureAt = &at
+	case DecisionMerged:
+		s.ConsecutiveFailures = 0
+		s.QuarantinedUntil = nil
+		s.MergedAt = &at
+		s.MergeRunID = runID
+		s.MergeCommitSHA = result.MergeCommitSHA
+		s.RevertedAt = nil
+		s.RevertedBy = ""
+		s.RevertIID = 0
+	}
+	s.History = append(s.History, StateEvent{
+		RunID:    runID,
+		At:       result.StartedAt,
+		SHA:      result.SHA,
+		Decision: result.Decision,
+		Rule:     result.Rule,
+		Reason:   result.Reason,
+	})
+	if len(s.History) > maxStateHistory {
+		s.History = s.History[len(s.History)-maxStateHistory:]
+	}
+}
+
+// StateStore persists history of merge requests across runs.
+type StateStore interface {
+	// Get returns state of a merge request, nil if merge request is unknown.
+	Get(project string, iid int64) (*MergeRequestState, error)
+	// List returns states of all known merge requests of project ordered by iid.
+	List(project string) ([]*MergeRequestState, error)
+	// Put records state of a merge request.
+	Put(project string, state *MergeRequestState) error
+	// Flush persists recorded states.
+	Flush() error
+}
+
+func checkStateBackend(backend string) error {
+	switch backend {
+	case StateBackendFile, StateBackendNone:
+		return nil
+	}
+	return fmt.Errorf("unknown state backend '%s', must be one of: %s, %s", backend, StateBackendFile, StateBackendNone)
+}
+
+// newStateStore creates a state store for given backend, nil is returned when state is disabled.
+func newStateStore(backend, path string, retention time.Duration) (StateStore, error) {
+	if backend == StateBackendFile {
+		store, err := NewFileStateStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store.Retention = retention
+		return store, nil
+	}
+	return nil, nil
+}
+
+// FileStateStore is a StateStore keeping states in memory and writing them in a json file on flush.
+// Processes sharing the file lock it while reading and writing it, on flush only states put by the process
+// replace the ones in file so concurrent runs don't overwrite each other.
+type FileStateStore struct {
+	// Retention is how long states of merge requests no longer evaluated, because they are merged or closed,
+	// are kept. Zero keeps them forever.
+	Retention time.Duration
+
+	path     string
+	projects map[string]map[int64]*MergeRequestState
+	dirty    map[string]map[int64]bool
+}
+
+type stateFile struct {
+	Projects map[string]map[int64]*MergeRequestState `json:"projects"`
+}
+
+// NewFileStateStore creates a store backed by file at path, file is created on first flush.
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	s := &FileStateStore{path: path}
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	s.projects, err = readStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func readStateFile(path string) (map[string]map[int64]*MergeRequestState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]map[int64]*MergeRequestState), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when reading state file: %s", err.Error())
+	}
+	var content stateFile
+	err = json.Unmarshal(b, &content)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading state file %s: %s", path, err.Error())
+	}
+	if content.Projects == nil {
+		return make(map[string]map[int64]*MergeRequestState), nil
+	}
+	return content.Projects, nil
+}
+
+// lock locks state file, shared to read it and exclusive to write it, with a lock file next to it
+// as state file itself is replaced on flush. It returns the function releasing the lock.
+func (s *FileStateStore) lock(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error when opening state lock file: %s", err.Error())
+	}
+	err = lockFile(f, exclusive)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("error when locking state file: %s", err.Error())
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+func (s *FileStateStore) Get(project string, iid int64) (*MergeRequestState, error) {
+	return s.projects[project][iid], nil
+}
+
+func (s *FileStateStore) List(project string) ([]*MergeRequestState, error) {
+	states := make([]*MergeRequestState, 0, len(s.projects[project]))
+	for _, state := range s.projects[project] {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].IID < states[j].IID
+	})
+	return states, nil
+}
+
+func (s *FileStateStore) Put(project string, state *MergeRequestState) error {
+	if s.projects[project] == nil {
+		s.projects[project] = make(map[int64]*MergeRequestState)
+	}
+	s.projects[project][state.IID] = state
+	if s.dirty == nil {
+		s.dirty = make(map[string]map[int64]bool)
+	}
+	if s.dirty[project] == nil {
+		s.dirty[project] = make(map[int64]bool)
+	}
+	s.dirty[project][state.IID] = true
+	return nil
+}
+
+// lastActivity returns the last time merge request was evaluated, merged or reverted.
+func (s *MergeRequestState) lastActivity() time.Time {
+	last := s.LastEvaluatedAt
+	for _, at := range []*time.Time{s.MergedAt, s.RevertedAt} {
+		if at != nil && at.After(last) {
+			last = *at
+		}
+	}
+	return last
+}
+
+// prune removes states of merge requests without activity for longer than retention, merge requests
+// still opened are evaluated on every run and are kept.
+func (s *FileStateStore) prune(now time.Time) {
+	if s.Retention <= 0 {
+		return
+	}
+	for _, states := range s.projects {
+		for iid, state := range states {
+			if now.Sub(state.lastActivity()) > s.Retention {
+				delete(states, iid)
+			}
+		}
+	}
+}
+
+// Flush writes states in a temporary file renamed afterward, so state file is never left half written.
+// States put since last flush are merged with the ones written meanwhile by other processes.
+func (s *FileStateStore) Flush() error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	projects, err := readStateFile(s.path)
+	if err != nil {
+		return err
+	}
+	for project, iids := range s.dirty {
+		if projects[project] == nil {
+			projects[project] = make(map[int64]*MergeRequestState)
+		}
+		for iid := range iids {
+			projects[project][iid] = s.projects[project][iid]
+		}
+	}
+	s.projects = projects
+	s.dirty = nil
+	s.prune(time.Now())
+	b, err := json.MarshalIndent(stateFile{Projects: s.projects}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(b, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// mergeRequestStates returns states of all known merge requests of project, nil when state is disabled.
+func (a *AcceptMr) mergeRequestStates() []*MergeRequestState {
+	if a.State == nil {
+		return nil
+	}
+	states, err := a.State.List(a.ProjectName)
+	if err != nil {
+		log.Warnf("could not read merge request states: %s", err.Error())
+		return nil
+	}
+	return states
+}
+
+// mergeRequestState returns state of merge request from store, a new state is returned if it is unknown.
+// It returns nil when there is no store or the store can't be read.
+func (a *AcceptMr) mergeRequestState(iid int64) *MergeRequestState {
+	if a.State == nil {
+		return nil
+	}
+	state, err := a.State.Get(a.ProjectName, iid)
+	if err != nil {
+		log.WithField("iid", iid).Warnf("could not read merge request state: %s", err.Error())
+		return nil
+	}
+	if state == nil {
+		state = &MergeRequestState{IID: iid}
+	}
+	return state
+}
+
+// recordState records result of merge request in state and saves it in store,
+// merge requests not processed are not recorded.
+func (a *AcceptMr) recordState(runID string, state *MergeRequestState, result *MergeRequestResult, labels []string) error {
+	if state == nil || result.Decision == DecisionUnprocessed {
+		return nil
+	}
+	state.record(runID, result, labels)
+	if result.Decision == DecisionMerged {
+		state.VerificationPending = a.Verification.enabled() && result.MergeCommitSHA != ""
+	}
+	if result.Decision == DecisionFailed {
+		result.NextRetryAt = a.Quarantine.update(state, time.Now())
+	}
+	return a.State.Put(a.ProjectName, state)
+}
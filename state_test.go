@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+	state, err := store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+
+	result := &MergeRequestResult{IID: 2, SHA: "abc", Decision: DecisionFailed, Rule: "merge_error", Reason: "boom"}
+	state = &MergeRequestState{IID: 2}
+	state.record("run-1", result, []string{"bot"})
+	assert.NoError(t, store.Put("test-project", state))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 1}))
+	assert.NoError(t, store.Flush())
+
+	store, err = NewFileStateStore(path)
+	assert.NoError(t, err)
+	states, err := store.List("test-project")
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+	assert.Equal(t, int64(1), states[0].IID)
+	state = states[1]
+	assert.Equal(t, 1, state.Evaluations)
+	assert.Equal(t, 1, state.Failures)
+	assert.Equal(t, []string{"bot"}, state.Labels)
+	assert.Equal(t, &StateEvent{RunID: "run-1", At: state.FirstSeenAt, SHA: "abc", Decision: DecisionFailed, Rule: "merge_error", Reason: "boom"}, state.LastEvent())
+
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err = NewFileStateStore(path)
+	assert.Error(t, err)
+}
+
+func TestFileStateStore_concurrentRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	first, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+	second, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, first.Put("test-project", &MergeRequestState{IID: 1, Evaluations: 1}))
+	assert.NoError(t, second.Put("test-project", &MergeRequestState{IID: 2, Evaluations: 1}))
+	assert.NoError(t, first.Flush())
+	assert.NoError(t, second.Flush())
+
+	store, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+	states, err := store.List("test-project")
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+}
+
+func TestFileStateStore_prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+	store.Retention = 24 * time.Hour
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 1, LastEvaluatedAt: old}))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 2, LastEvaluatedAt: recent}))
+	assert.NoError(t, store.Put("test-project", &MergeRequestState{IID: 3, LastEvaluatedAt: old, RevertedAt: &recent}))
+	assert.NoError(t, store.Flush())
+
+	store, err = NewFileStateStore(path)
+	assert.NoError(t, err)
+	states, err := store.List("test-project")
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+	assert.Equal(t, int64(2), states[0].IID)
+	assert.Equal(t, int64(3), states[1].IID)
+}
+
+func TestMergeRequestState_historyIsBounded(t *testing.T) {
+	state := &MergeRequestState{IID: 1}
+	for i := 0; i < maxStateHistory+10; i++ {
+		state.record("run", &MergeRequestResult{IID: 1, Decision: DecisionSkipped}, nil)
+	}
+	assert.Len(t, state.History, maxStateHistory)
+	assert.Equal(t, maxStateHistory+10, state.Evaluations)
+}
+
+func TestAcceptMr_recordsState(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo", "sha": "abc", "target_branch": "main"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged", "merge_commit_sha": "def"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStateStore(path)
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", State: store}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+
+	store, err = NewFileStateStore(path)
+	assert.NoError(t, err)
+	state, err := store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", state.SHA)
+	assert.Equal(t, "main", state.TargetBranch)
+	assert.NotNil(t, state.MergedAt)
+	assert.Equal(t, acceptMr.LastReport.RunID, state.MergeRunID)
+	assert.Equal(t, "def", state.MergeCommitSHA)
+	assert.Equal(t, DecisionMerged, state.LastEvent().Decision)
+}
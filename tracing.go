@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace exporters supported by --trace-exporter.
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusOk    = 1
+	spanStatusError = 2
+)
+
+// Span is a timed operation of a trace, it follows OpenTelemetry data model.
+type Span struct {
+	tracer        *Tracer
+	traceID       string
+	spanID        string
+	parentSpanID  string
+	name          string
+	kind          int
+	start         time.Time
+	end           time.Time
+	attributes    map[string]any
+	statusCode    int
+	statusMessage string
+}
+
+// SetAttribute sets an attribute on span, value must be a string, a bool or an integer.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.statusCode = spanStatusError
+	s.statusMessage = err.Error()
+}
+
+// End ends span and gives it to tracer for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if s.statusCode == 0 {
+		s.statusCode = spanStatusOk
+	}
+	s.tracer.ended(s)
+}
+
+// SpanExporter sends ended spans to a backend.
+type SpanExporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer creates spans and exports them when flushed, spans started while another one
+// is active become its children. A run being sequential, active spans are tracked as a stack.
+// All methods are safe to call on a nil Tracer.
+type Tracer struct {
+	mu       sync.Mutex
+	exporter SpanExporter
+	active   []*Span
+	finished []*Span
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts a span as child of current active span and makes it active.
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := t.newSpan(name, spanKindInternal)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = append(t.active, span)
+	return span
+}
+
+// startClient starts a client span as child of current active span without making it active.
+func (t *Tracer) startClient(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return t.newSpan(name, spanKindClient)
+}
+
+func (t *Tracer) newSpan(name string, kind int) *Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &Span{
+		tracer:     t,
+		spanID:     randomHex(8),
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]any),
+	}
+	if len(t.active) > 0 {
+		parent := t.active[len(t.active)-1]
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	return span
+}
+
+func (t *Tracer) ended(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.active) - 1; i >= 0; i-- {
+		if t.active[i] == span {
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			break
+		}
+	}
+	t.finished = append(t.finished, span)
+}
+
+// Flush exports all ended spans.
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.finished
+	t.finished = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.Export(spans)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tracingTransport is an http.RoundTripper creating a client span for each request made to gitlab api.
+type tracingTransport struct {
+	next   http.RoundTripper
+	tracer *Tracer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := t.tracer.startClient(req.Method)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Hostname())
+	span.SetAttribute("url.path", req.URL.Path)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+	}
+	if resp != nil {
+		span.SetAttribute("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			span.SetError(fmt.Errorf("%s", resp.Status))
+		}
+	}
+	span.End()
+	return resp, err
+}
+
+// otlpExporter sends spans to an OpenTelemetry collector with OTLP over http in json encoding.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpExporter) Export(spans []*Span) error {
+	b, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// stdoutExporter writes spans as json lines, it is meant for debugging.
+type stdoutExporter struct {
+	w io.Writer
+}
+
+func (e *stdoutExporter) Export(spans []*Span) error {
+	enc := json.NewEncoder(e.w)
+	for _, span := range spans {
+		err := enc.Encode(otlpSpan(span))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func otlpRequest(spans []*Span) map[string]any {
+	otlpSpans := make([]map[string]any, len(spans))
+	for i, span := range spans {
+		otlpSpans[i] = otlpSpan(span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{"service.name": "accept-mr"}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "accept-mr"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpSpan(span *Span) map[string]any {
+	otlp := map[string]any{
+		"traceId":           span.traceID,
+		"spanId":            span.spanID,
+		"name":              span.name,
+		"kind":              span.kind,
+		"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(span.attributes),
+		"status": map[string]any{
+			"code":    span.statusCode,
+			"message": span.statusMessage,
+		},
+	}
+	if span.parentSpanID != "" {
+		otlp["parentSpanId"] = span.parentSpanID
+	}
+	return otlp
+}
+
+func otlpAttributes(attributes map[string]any) []any {
+	otlp := make([]any, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]any
+		switch value := value.(type) {
+		case bool:
+			v = map[string]any{"boolValue": value}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+		otlp = append(otlp, map[string]any{"key": key, "value": v})
+	}
+	return otlp
+}
+
+func checkTraceExporter(exporter string) error {
+	switch exporter {
+	case TraceExporterNone, TraceExporterStdout, TraceExporterOTLP:
+		return nil
+	}
+	return fmt.Errorf("unknown trace exporter '%s', must be one of: %s, %s, %s",
+		exporter, TraceExporterNone, TraceExporterStdout, TraceExporterOTLP)
+}
+
+// newTracerFromExporter creates a tracer for given exporter, nil is returned when tracing is disabled.
+func newTracerFromExporter(exporter, endpoint string) *Tracer {
+	switch exporter {
+	case TraceExporterStdout:
+		return NewTracer(&stdoutExporter{w: os.Stdout})
+	case TraceExporterOTLP:
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		if !strings.HasSuffix(endpoint, "/v1/traces") {
+			endpoint += "/v1/traces"
+		}
+		return NewTracer(&otlpExporter{
+			endpoint: endpoint,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+type memoryExporter struct {
+	spans []*Span
+}
+
+func (e *memoryExporter) Export(spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestAcceptMr_RunTracing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Bump foo"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL), gitlab.WithHTTPClient(&http.Client{
+		Transport: &tracingTransport{next: http.DefaultTransport, tracer: tracer},
+	}))
+	assert.NoError(t, err)
+
+	acceptMr := &AcceptMr{Client: client, ProjectName: "test-project", Tracer: tracer}
+	err = acceptMr.Run()
+	assert.NoError(t, err)
+
+	assert.Len(t, exporter.spans, 4)
+	for _, span := range exporter.spans {
+		assert.Equal(t, exporter.spans[0].traceID, span.traceID)
+	}
+	run := findSpan(exporter.spans, "run")
+	mr := findSpan(exporter.spans, "merge request")
+	list := findSpan(exporter.spans, http.MethodGet)
+	merge := findSpan(exporter.spans, http.MethodPut)
+	assert.Equal(t, "", run.parentSpanID)
+	assert.Equal(t, run.spanID, mr.parentSpanID)
+	assert.Equal(t, run.spanID, list.parentSpanID)
+	assert.Equal(t, mr.spanID, merge.parentSpanID)
+	assert.Equal(t, "merged", mr.attributes["decision"])
+	assert.Equal(t, int64(1), mr.attributes["mr.iid"])
+}
+
+func findSpan(spans []*Span, name string) *Span {
+	for _, span := range spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTraced(t *testing.T) {
+	exporter := &memoryExporter{}
+	acceptMr := &AcceptMr{ProjectName: "test-project", Tracer: NewTracer(exporter)}
+	err := traced(acceptMr, "batch", func() error {
+		return fmt.Errorf("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	if assert.Len(t, exporter.spans, 1) {
+		assert.Equal(t, "batch", exporter.spans[0].name)
+		assert.Equal(t, "failed", exporter.spans[0].statusMessage)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// RevertLabel is set on merge requests reverting a merge, merges into their target branch are paused
+// while its pipeline does not succeed.
+const RevertLabel = "automerge-revert"
+
+// revertBranchPrefix is the prefix of branches of revert merge requests, iid of reverted merge request and
+// short sha of reverted commit are appended so that each merge of a merge request has its own branch.
+const revertBranchPrefix = "accept-mr/revert/"
+
+// revertBranch returns the branch of the merge request reverting a merge.
+func revertBranch(merge *MergeRequestState) string {
+	sha := merge.MergeCommitSHA
+	if len(sha) > 8 {
+		sha = sha[:8]
+	}
+	return fmt.Sprintf("%s%d-%s", revertBranchPrefix, merge.IID, sha)
+}
+
+// Verification waits for the pipeline of merge commit on target branch after a merge. When it fails,
+// merge is reverted with a merge request and merges into target branch are paused until it is green again.
+type Verification struct {
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func (v *Verification) enabled() bool {
+	return v != nil
+}
+
+// mergedCommitSHA returns the commit added to target branch by a merge.
+func mergedCommitSHA(info *gitlab.MergeRequest) string {
+	if info.MergeCommitSHA != "" {
+		return info.MergeCommitSHA
+	}
+	return info.SquashCommitSHA
+}
+
+// lastPipeline returns the most recent pipeline of ref, on given commit if sha is set.
+func (a *AcceptMr) lastPipeline(ctx context.Context, ref, sha string) (*gitlab.PipelineInfo, error) {
+	opt := &gitlab.ListProjectPipelinesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 1},
+		Ref:         &ref,
+		OrderBy:     gitlab.Ptr("id"),
+		Sort:        gitlab.Ptr("desc"),
+	}
+	if sha != "" {
+		opt.SHA = &sha
+	}
+	pipelines, _, err := a.Client.Pipelines.ListProjectPipelines(a.ProjectName, opt, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if len(pipelines) == 0 {
+		return nil, nil
+	}
+	return pipelines[0], nil
+}
+
+func isPipelineFinished(status string) bool {
+	switch gitlab.BuildStateValue(status) {
+	case gitlab.Success, gitlab.Failed, gitlab.Canceled, gitlab.Skipped:
+		return true
+	}
+	return false
+}
+
+// waitPipeline waits for the pipeline of a commit on ref to finish, nil is returned when it did not
+// finish before deadline.
+func (a *AcceptMr) waitPipeline(ctx context.Context, ref, sha string, deadline time.Time) (*gitlab.PipelineInfo, error) {
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	ticker := time.NewTicker(a.Verification.Interval)
+	defer ticker.Stop()
+	for {
+		pipeline, err := a.lastPipeline(ctx, ref, sha)
+		if err != nil {
+			return nil, err
+		}
+		if pipeline != nil && isPipelineFinished(pipeline.Status) {
+			return pipeline, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadPausedBranches finds branches where merges are paused: target branches of revert merge requests
+// whose pipeline does not succeed. Revert merge requests no longer opened are released once their
+// target branch is green.
+func (a *AcceptMr) loadPausedBranches(ctx context.Context) error {
+	a.pausedBranches = nil
+	if !a.Verification.enabled() {
+		return nil
+	}
+	reverts, err := gitlab.ScanAndCollect(func(p gitlab.PaginationOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+		return a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: gitlab.ListOptions{PerPage: 100},
+			Labels:      &gitlab.LabelOptions{RevertLabel},
+			State:       gitlab.Ptr("all"),
+		}, p, gitlab.WithContext(ctx))
+	})
+	if err != nil {
+		return fmt.Errorf("error when listing revert merge requests: %s", err.Error())
+	}
+	green := make(map[string]bool)
+	for _, revert := range reverts {
+		if _, ok := green[revert.TargetBranch]; !ok {
+			pipeline, err := a.lastPipeline(ctx, revert.TargetBranch, "")
+			if err != nil {
+				return fmt.Errorf("error when reading pipeline of branch %s: %s", revert.TargetBranch, err.Error())
+			}
+			green[revert.TargetBranch] = pipeline != nil && pipeline.Status == string(gitlab.Success)
+		}
+		if !green[revert.TargetBranch] {
+			a.pauseBranch(revert.TargetBranch, fmt.Sprintf("merges into %s are paused until its pipeline succeeds, see revert !%d", revert.TargetBranch, revert.IID))
+			continue
+		}
+		if revert.State == "opened" {
+			continue
+		}
+		_, _, err := a.Client.MergeRequests.UpdateMergeRequest(a.ProjectName, revert.IID, &gitlab.UpdateMergeRequestOptions{
+			RemoveLabels: &gitlab.LabelOptions{RevertLabel},
+		}, gitlab.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error when releasing revert merge request !%d: %s", revert.IID, err.Error())
+		}
+		log.Infof("Pipeline of %s succeeds again, merges are resumed", revert.TargetBranch)
+	}
+	return nil
+}
+
+func (a *AcceptMr) pauseBranch(branch, reason string) {
+	if a.pausedBranches == nil {
+		a.pausedBranches = make(map[string]string)
+	}
+	if _, ok := a.pausedBranches[branch]; !ok {
+		a.pausedBranches[branch] = reason
+	}
+}
+
+// checkBranch fails when merges into target branch are paused, revert merge requests are still allowed
+// as they are the way to turn branch green again.
+func (a *AcceptMr) checkBranch(mr *gitlab.BasicMergeRequest) Check {
+	if reason, paused := a.pausedBranches[mr.TargetBranch]; paused && !hasLabel(mr, RevertLabel) {
+		return failed("Branch", reason)
+	}
+	return passed("Branch", "merges into target branch are not paused")
+}
+
+// openRevertMergeRequest reverts commit of a merge on a new branch and opens a merge request with it.
+// It can be retried after a failure: a revert merge request already opened is returned and a branch
+// left by a previous attempt is replaced.
+func (a *AcceptMr) openRevertMergeRequest(ctx context.Context, merge *MergeRequestState, reason string) (*gitlab.BasicMergeRequest, error) {
+	branch := revertBranch(merge)
+	sha := merge.MergeCommitSHA
+	opened, _, err := a.Client.MergeRequests.ListProjectMergeRequests(a.ProjectName, &gitlab.ListProjectMergeRequestsOptions{
+		SourceBranch: &branch,
+		State:        gitlab.Ptr("opened"),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error when reading revert merge requests: %s", err.Error())
+	}
+	if len(opened) > 0 {
+		return opened[0], nil
+	}
+	err = a.deleteBranch(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = a.Client.Branches.CreateBranch(a.ProjectName, &gitlab.CreateBranchOptions{
+		Branch: &branch,
+		Ref:    &merge.TargetBranch,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error when creating revert branch: %s", err.Error())
+	}
+	_, _, err = a.Client.Commits.RevertCommit(a.ProjectName, sha, &gitlab.RevertCommitOptions{
+		Branch: &branch,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		if deleteErr := a.deleteBranch(ctx, branch); deleteErr != nil {
+			log.Warnf("could not delete revert branch %s: %s", branch, deleteErr.Error())
+		}
+		return nil, fmt.Errorf("error when reverting commit %s: %s", sha, err.Error())
+	}
+	revert, _, err := a.Client.MergeRequests.CreateMergeRequest(a.ProjectName, &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.Ptr(fmt.Sprintf("Revert \"%s\"", undraftTitle(merge.Title))),
+		Description:        gitlab.Ptr(fmt.Sprintf("Reverts !%d (%s): %s.", merge.IID, sha, reason)),
+		SourceBranch:       &branch,
+		TargetBranch:       &merge.TargetBranch,
+		Labels:             &gitlab.LabelOptions{RevertLabel},
+		RemoveSourceBranch: gitlab.Ptr(true),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		if deleteErr := a.deleteBranch(ctx, branch); deleteErr != nil {
+			log.Warnf("could not delete revert branch %s: %s", branch, deleteErr.Error())
+		}
+		return nil, fmt.Errorf("error when creating revert merge request: %s", err.Error())
+	}
+	return &revert.BasicMergeRequest, nil
+}
+
+func verificationPausedReason(branch string, iid int64) string {
+	return fmt.Sprintf("merges into %s are paused until pipeline of merge commit of !%d finishes", branch, iid)
+}
+
+// awaitVerification pauses merges into target branch of a merge until the pipeline of its merge commit
+// is verified. Fast-forward merges add no merge commit and are never verified.
+func (a *AcceptMr) awaitVerification(mr *gitlab.BasicMergeRequest, result *MergeRequestResult) {
+	if !a.Verification.enabled() {
+		return
+	}
+	if result.MergeCommitSHA == "" {
+		a.mrLogger(result).Warn("No merge commit, pipeline of target branch is not verified")
+		return
+	}
+	a.pauseBranch(mr.TargetBranch, verificationPausedReason(mr.TargetBranch, mr.IID))
+}
+
+// verifyMerges verifies merges whose verification is pending in state. When wait is set, pipelines of
+// merge commits are waited for until verification timeout, counted from merge, otherwise their status is
+// only read. Merges not verified yet stay pending for next run and merges into their target branch are
+// paused meanwhile.
+func (a *AcceptMr) verifyMerges(ctx context.Context, report *RunReport, wait bool) {
+	for _, state := range a.mergeRequestStates() {
+		// a merge whose verification failed is attempted again on next run only
+		if !state.VerificationPending || a.verifyFailures[state.IID] {
+			continue
+		}
+		entry := log.WithFields(log.Fields{"project": a.ProjectName, "iid": state.IID, "target_branch": state.TargetBranch})
+		err := a.verifyMerge(ctx, state, wait, entry)
+		if err != nil {
+			entry.Errorf("could not verify merge: %s", err.Error())
+			if a.verifyFailures == nil {
+				a.verifyFailures = make(map[int64]bool)
+			}
+			a.verifyFailures[state.IID] = true
+			report.addError(fmt.Errorf("error when verifying merge of !%d: %s", state.IID, err.Error()))
+		}
+		for _, result := range report.MergeRequests {
+			if result.IID == state.IID && state.RevertIID != 0 {
+				result.RevertIID = state.RevertIID
+			}
+		}
+		if err := a.State.Put(a.ProjectName, state); err != nil {
+			entry.Warnf("could not record merge verification: %s", err.Error())
+		}
+	}
+}
+
+// verifyMerge checks the pipeline of merge commit, on failure merge is reverted and merges into
+// target branch are paused.
+func (a *AcceptMr) verifyMerge(ctx context.Context, state *MergeRequestState, wait bool, entry *log.Entry) error {
+	deadline := state.MergedAt.Add(a.Verification.Timeout)
+	var pipeline *gitlab.PipelineInfo
+	var err error
+	if wait && time.Now().Before(deadline) {
+		entry.Infof("Waiting for pipeline of merge commit %s ...", state.MergeCommitSHA)
+		pipeline, err = a.waitPipeline(ctx, state.TargetBranch, state.MergeCommitSHA, deadline)
+	} else {
+		pipeline, err = a.lastPipeline(ctx, state.TargetBranch, state.MergeCommitSHA)
+	}
+	if ctx.Err() != nil {
+		a.pauseBranch(state.TargetBranch, verificationPausedReason(state.TargetBranch, state.IID))
+		entry.Info("Run stopped, pipeline of merge commit is verified on next run")
+		return nil
+	}
+	if err != nil {
+		a.pauseBranch(state.TargetBranch, verificationPausedReason(state.TargetBranch, state.IID))
+		return fmt.Errorf("error when reading pipeline of merge commit: %s", err.Error())
+	}
+	if pipeline == nil || !isPipelineFinished(pipeline.Status) {
+		if time.Now().Before(deadline) {
+			a.pauseBranch(state.TargetBranch, verificationPausedReason(state.TargetBranch, state.IID))
+			return nil
+		}
+		entry.Warnf("Pipeline of merge commit did not finish within %s, it is not verified", a.Verification.Timeout)
+		state.VerificationPending = false
+		return nil
+	}
+	if pipeline.Status != string(gitlab.Failed) {
+		entry.Infof("Pipeline of merge commit finished with status %s", pipeline.Status)
+		state.VerificationPending = false
+		return nil
+	}
+	reason := fmt.Sprintf("pipeline %s of merge commit failed", pipeline.WebURL)
+	entry.Warnf("Reverting merge, %s", reason)
+	// on failure verification stays pending so that revert is attempted again on next run
+	revert, err := a.openRevertMergeRequest(ctx, state, reason)
+	if err != nil {
+		a.pauseBranch(state.TargetBranch, fmt.Sprintf("merges into %s are paused, pipeline of merge commit of !%d failed", state.TargetBranch, state.IID))
+		return err
+	}
+	// recorded as any revert so that revert command does not revert it again
+	now := time.Now()
+	state.VerificationPending = false
+	state.RevertIID = revert.IID
+	state.RevertedAt = &now
+	state.RevertedBy = fmt.Sprintf("!%d", revert.IID)
+	a.pauseBranch(state.TargetBranch, fmt.Sprintf("merges into %s are paused until its pipeline succeeds, see revert !%d", state.TargetBranch, revert.IID))
+	body := fmt.Sprintf("Pipeline of merge commit %s failed on `%s`, merge is reverted by !%d. Automatic merges into `%s` are paused until its pipeline succeeds.",
+		state.MergeCommitSHA, state.TargetBranch, revert.IID, state.TargetBranch)
+	_, _, err = a.Client.Notes.CreateMergeRequestNote(a.ProjectName, state.IID, &gitlab.CreateMergeRequestNoteOptions{
+		Body: &body,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when commenting on merge request: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestAcceptMr_verifyMerge(t *testing.T) {
+	reverts := `[]`
+	branchStatus, mergeStatus := "failed", "failed"
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			if r.Method == http.MethodPost {
+				calls = append(calls, "create revert merge request")
+				_, _ = w.Write([]byte(`{"iid": 3}`))
+				return
+			}
+			if r.URL.Query().Get("labels") == RevertLabel {
+				_, _ = w.Write([]byte(reverts))
+				return
+			}
+			if r.URL.Query().Get("source_branch") != "" {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"iid": 1, "title": "Feature", "target_branch": "main"}, {"iid": 2, "title": "Other", "target_branch": "main"}]`))
+		case "/api/v4/projects/test-project/merge_requests/1/merge":
+			_, _ = w.Write([]byte(`{"state": "merged", "merge_commit_sha": "abc"}`))
+		case "/api/v4/projects/test-project/merge_requests/2/merge":
+			_, _ = w.Write([]byte(`{"state": "merged", "merge_commit_sha": "def"}`))
+		case "/api/v4/projects/test-project/pipelines":
+			assert.Equal(t, "main", r.URL.Query().Get("ref"))
+			switch r.URL.Query().Get("sha") {
+			case "abc":
+				_, _ = w.Write([]byte(`[{"id": 10, "status": "` + mergeStatus + `", "web_url": "https://gitlab/pipelines/10"}]`))
+			case "def":
+				_, _ = w.Write([]byte(`[{"id": 11, "status": "success"}]`))
+			default:
+				_, _ = w.Write([]byte(`[{"id": 12, "status": "` + branchStatus + `"}]`))
+			}
+		case "/api/v4/projects/test-project/repository/branches":
+			assert.Equal(t, http.MethodPost, r.Method)
+			branch := map[string]string{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&branch))
+			calls = append(calls, "create branch "+branch["branch"])
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/repository/commits/abc/revert":
+			calls = append(calls, "revert abc")
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			calls = append(calls, "note")
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/merge_requests/3":
+			calls = append(calls, "release revert")
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	store, err := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+	acceptMr := &AcceptMr{
+		Client:       client,
+		ProjectName:  "test-project",
+		State:        store,
+		Verification: &Verification{Timeout: time.Second, Interval: time.Millisecond},
+	}
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	results := acceptMr.LastReport.MergeRequests
+	assert.Equal(t, DecisionMerged, results[0].Decision)
+	assert.Equal(t, int64(3), results[0].RevertIID)
+	assert.Equal(t, []string{"create branch accept-mr/revert/1-abc", "revert abc", "create revert merge request", "note"}, calls)
+	assert.Equal(t, DecisionSkipped, results[1].Decision)
+	assert.Equal(t, "branch check failed: merges into main are paused until pipeline of merge commit of !1 finishes", results[1].Reason)
+	state, err := store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.False(t, state.VerificationPending)
+	assert.Equal(t, int64(3), state.RevertIID)
+	assert.Equal(t, "!3", state.RevertedBy)
+
+	// automatic revert is not reverted again by revert command
+	calls = nil
+	err = acceptMr.Revert(context.Background(), RevertOptions{IIDs: []int64{1}})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	// revert is merged but branch is still red
+	reverts = `[{"iid": 3, "state": "merged", "target_branch": "main"}]`
+	calls = nil
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionSkipped, acceptMr.LastReport.MergeRequests[0].Decision)
+	assert.Empty(t, calls)
+
+	// branch is green again
+	branchStatus, mergeStatus = "success", "success"
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "release revert", calls[0])
+	assert.Equal(t, DecisionMerged, acceptMr.LastReport.MergeRequests[0].Decision)
+	state, err = store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.False(t, state.VerificationPending)
+	assert.Zero(t, state.RevertIID)
+}
+
+func TestAcceptMr_verifyMergesOnNextRun(t *testing.T) {
+	status := "running"
+	createFails := true
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/test-project/merge_requests":
+			if r.Method == http.MethodPost {
+				calls = append(calls, "create revert merge request")
+				if createFails {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"message": "boom"}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"iid": 3}`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		case "/api/v4/projects/test-project/repository/branches/accept-mr/revert/1-abc":
+			calls = append(calls, "delete branch")
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v4/projects/test-project/pipelines":
+			_, _ = w.Write([]byte(`[{"id": 10, "status": "` + status + `"}]`))
+		case "/api/v4/projects/test-project/repository/branches":
+			calls = append(calls, "create branch")
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/repository/commits/abc/revert":
+			calls = append(calls, "revert abc")
+			_, _ = w.Write([]byte(`{}`))
+		case "/api/v4/projects/test-project/merge_requests/1/notes":
+			calls = append(calls, "note")
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := gitlab.NewClient("", gitlab.WithBaseURL(ts.URL))
+	assert.NoError(t, err)
+
+	store, err := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+	mergedAt := time.Now()
+	state := &MergeRequestState{IID: 1, TargetBranch: "main", MergedAt: &mergedAt, MergeCommitSHA: "abc", VerificationPending: true}
+	assert.NoError(t, store.Put("test-project", state))
+	acceptMr := &AcceptMr{
+		Client:       client,
+		ProjectName:  "test-project",
+		State:        store,
+		Verification: &Verification{Timeout: time.Hour, Interval: time.Millisecond},
+	}
+
+	// waiting stops with the run, merge stays pending and its branch paused
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	acceptMr.verifyMerges(ctx, newRunReport("test-project"), true)
+	assert.True(t, state.VerificationPending)
+	assert.Equal(t, verificationPausedReason("main", 1), acceptMr.pausedBranches["main"])
+
+	// pipeline still running on next run
+	acceptMr.pausedBranches = nil
+	acceptMr.verifyMerges(context.Background(), newRunReport("test-project"), false)
+	assert.True(t, state.VerificationPending)
+	assert.Equal(t, verificationPausedReason("main", 1), acceptMr.pausedBranches["main"])
+	assert.Empty(t, calls)
+
+	// revert branch is cleaned up when revert merge request can't be created, revert is retried on next run
+	status = "failed"
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"delete branch", "create branch", "revert abc", "create revert merge request", "delete branch"}, calls)
+	assert.Len(t, acceptMr.LastReport.Errors, 1)
+	state, err = store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.True(t, state.VerificationPending)
+	assert.Zero(t, state.RevertIID)
+
+	createFails = false
+	calls = nil
+	err = acceptMr.RunContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"delete branch", "create branch", "revert abc", "create revert merge request", "note"}, calls)
+	state, err = store.Get("test-project", 1)
+	assert.NoError(t, err)
+	assert.False(t, state.VerificationPending)
+	assert.Equal(t, int64(3), state.RevertIID)
+}